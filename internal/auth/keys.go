@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	keysKey   = "api-keys"
+	hashesKey = "api-key-hashes"
+	// secretPrefix marks API keys of the service, so leaked ones are easy to find.
+	secretPrefix = "cmx_"
+	// shownLength is how much of the secret is kept to tell keys apart.
+	shownLength = len(secretPrefix) + 6
+)
+
+var (
+	ErrKeyNotFound = errors.New("api key not found")
+	ErrInvalidKey  = errors.New("invalid api key")
+)
+
+// Key is an API key of a client. Only the hash of its secret is stored.
+type Key struct {
+	KeyId string `json:"keyId"`
+	Name  string `json:"name"`
+	// Prefix is the start of the secret.
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Retention is how many seconds results of the key are kept, the default retention applies if it's zero.
+	Retention int64 `json:"retention,omitempty"`
+}
+
+// storedKey is a key with the hash of its secret.
+type storedKey struct {
+	*Key
+	Hash string `json:"hash"`
+}
+
+// Keys keeps API keys in redis.
+type Keys struct {
+	client *redis.Client
+}
+
+func NewKeys(client *redis.Client) *Keys {
+	return &Keys{client: client}
+}
+
+// Create makes a new key and returns it with its secret, which can't be read later.
+func (k *Keys) Create(ctx context.Context, name string, retention time.Duration) (*Key, string, error) {
+	keyId, err := uuid.NewRandom()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate uuid: %w", err)
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &Key{
+		KeyId:     keyId.String(),
+		Name:      name,
+		Prefix:    secret[:shownLength],
+		CreatedAt: time.Now().UTC(),
+		Retention: int64(retention.Seconds()),
+	}
+	stored := &storedKey{Key: key, Hash: Hash(secret)}
+	jsonKey, err := json.Marshal(stored)
+	if err != nil {
+		return nil, "", fmt.Errorf("marshal key to json: %w", err)
+	}
+
+	_, err = k.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, keysKey, key.KeyId, jsonKey)
+		pipe.HSet(ctx, hashesKey, stored.Hash, key.KeyId)
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("set key: %w", err)
+	}
+	return key, secret, nil
+}
+
+// List returns all keys, the oldest first.
+func (k *Keys) List(ctx context.Context) ([]*Key, error) {
+	jsonKeys, err := k.client.HVals(ctx, keysKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get keys: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(jsonKeys))
+	for _, jsonKey := range jsonKeys {
+		stored, err := unmarshalKey(jsonKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, stored.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// SetRetention changes how long results of the key are kept, zero applies the default retention.
+// It applies to transforms created from now on.
+func (k *Keys) SetRetention(ctx context.Context, keyId string, retention time.Duration) (*Key, error) {
+	stored, err := k.get(ctx, keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	stored.Retention = int64(retention.Seconds())
+	jsonKey, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("marshal key to json: %w", err)
+	}
+	err = k.client.HSet(ctx, keysKey, keyId, jsonKey).Err()
+	if err != nil {
+		return nil, fmt.Errorf("set key: %w", err)
+	}
+	return stored.Key, nil
+}
+
+// Revoke removes the key, so its secret is rejected from now on.
+func (k *Keys) Revoke(ctx context.Context, keyId string) error {
+	stored, err := k.get(ctx, keyId)
+	if err != nil {
+		return err
+	}
+
+	_, err = k.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, hashesKey, stored.Hash)
+		pipe.HDel(ctx, keysKey, keyId)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete key: %w", err)
+	}
+	return nil
+}
+
+// Authenticate returns the key of the secret.
+func (k *Keys) Authenticate(ctx context.Context, secret string) (*Key, error) {
+	if !strings.HasPrefix(secret, secretPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	keyId, err := k.client.HGet(ctx, hashesKey, Hash(secret)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get key id: %w", err)
+	}
+
+	jsonKey, err := k.client.HGet(ctx, keysKey, keyId).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get key: %w", err)
+	}
+	stored, err := unmarshalKey(jsonKey)
+	if err != nil {
+		return nil, err
+	}
+	return stored.Key, nil
+}
+
+func (k *Keys) get(ctx context.Context, keyId string) (*storedKey, error) {
+	jsonKey, err := k.client.HGet(ctx, keysKey, keyId).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get key: %w", err)
+	}
+	return unmarshalKey(jsonKey)
+}
+
+// Hash is the SHA-256 hex digest of the secret. Secrets are random, so they need no salt.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", fmt.Errorf("generate secret: %w", err)
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+func unmarshalKey(jsonKey string) (*storedKey, error) {
+	stored := &storedKey{}
+	err := json.Unmarshal([]byte(jsonKey), stored)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal key from json: %w", err)
+	}
+	return stored, nil
+}
+
+type contextKey struct{}
+
+// WithKey returns the context of a request authenticated with the key.
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key the request was authenticated with or nil if authentication is disabled.
+func FromContext(ctx context.Context) *Key {
+	key, _ := ctx.Value(contextKey{}).(*Key)
+	return key
+}
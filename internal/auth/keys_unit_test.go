@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewSecret_Unit(t *testing.T) {
+	secret, err := newSecret()
+	if err != nil {
+		t.Logf("new secret: %s", err.Error())
+		t.FailNow()
+	}
+	if !strings.HasPrefix(secret, secretPrefix) || len(secret) < shownLength+32 {
+		t.Logf("secret got: %q; expected %q and 43 random characters", secret, secretPrefix)
+		t.FailNow()
+	}
+
+	other, _ := newSecret()
+	if other == secret || Hash(other) == Hash(secret) {
+		t.Logf("two secrets or their hashes are the same: %q", secret)
+		t.FailNow()
+	}
+	if Hash(secret) != Hash(secret) || len(Hash(secret)) != 64 {
+		t.Logf("hash got: %q; expected a stable SHA-256 hex digest", Hash(secret))
+		t.FailNow()
+	}
+}
+
+func TestKeys_Authenticate_Unit(t *testing.T) {
+	// Secrets of other services are rejected before looking them up.
+	_, err := NewKeys(nil).Authenticate(context.Background(), "sk_other")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Logf("error got: %v; expected: %s", err, ErrInvalidKey)
+		t.FailNow()
+	}
+
+	if FromContext(context.Background()) != nil {
+		t.Logf("key of a context without key is not nil")
+		t.FailNow()
+	}
+	key := &Key{KeyId: "key"}
+	if FromContext(WithKey(context.Background(), key)) != key {
+		t.Logf("key of the context is not the key it was made with")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"comixifier/internal"
+	"comixifier/internal/state"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	keyPrefix = "result-cache:"
+	statsKey  = "result-cache-stats"
+)
+
+// Result is a stored transform result which is reused for the same input, comixifier and options.
+type Result struct {
+	Comixifier string             `json:"comixifier"`
+	File       string             `json:"file"`
+	Renditions []*state.Rendition `json:"renditions,omitempty"`
+}
+
+// Counters are lookups of cached results.
+type Counters struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Stats are lookups of cached results in total and by comixifier.
+type Stats struct {
+	Counters
+	Comixifiers map[string]*Counters `json:"comixifiers"`
+}
+
+// Cache keeps keys of transform results in redis by their input, so repeated transforms
+// don't pay comixifiers again. The zero TTL disables it.
+type Cache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewCache(client *redis.Client, ttl time.Duration) *Cache {
+	return &Cache{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+func (c *Cache) Enabled() bool {
+	return c.ttl > 0
+}
+
+func (c *Cache) TTL() time.Duration {
+	return c.ttl
+}
+
+// Key is a cache key of the result of the input with the SHA-256 hex digest by the comixifier with the options.
+func Key(inputHash string, comixifier string, options internal.Options) string {
+	return keyPrefix + Identity(inputHash, comixifier, options)
+}
+
+// Identity tells transforms which give the same result apart from others. Options must be normalized
+// with defaults, so the same settings give the same identity.
+func Identity(inputHash string, comixifier string, options internal.Options) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	encoded := make([]string, 0, len(names))
+	for _, name := range names {
+		encoded = append(encoded, url.QueryEscape(name)+"="+url.QueryEscape(options[name]))
+	}
+	return inputHash + ":" + comixifier + ":" + strings.Join(encoded, "&")
+}
+
+// Get returns the cached result or nil if there is none.
+func (c *Cache) Get(ctx context.Context, key string) (*Result, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+
+	jsonResult, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get cached result: %w", err)
+	}
+
+	result := &Result{}
+	err = json.Unmarshal(jsonResult, result)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal cached result from json: %w", err)
+	}
+	return result, nil
+}
+
+func (c *Cache) Put(ctx context.Context, key string, result *Result) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	jsonResult, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("marshal cached result to json: %w", err)
+	}
+
+	err = c.client.Set(ctx, key, jsonResult, c.ttl).Err()
+	if err != nil {
+		return fmt.Errorf("set cached result: %w", err)
+	}
+	return nil
+}
+
+// Delete drops the cached result whose file is gone.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	err := c.client.Del(ctx, key).Err()
+	if err != nil {
+		return fmt.Errorf("delete cached result: %w", err)
+	}
+	return nil
+}
+
+// Count records a lookup of a result of the comixifier.
+func (c *Cache) Count(ctx context.Context, comixifier string, hit bool) error {
+	field := comixifier + ":misses"
+	if hit {
+		field = comixifier + ":hits"
+	}
+
+	err := c.client.HIncrBy(ctx, statsKey, field, 1).Err()
+	if err != nil {
+		return fmt.Errorf("count cache lookup: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) Stats(ctx context.Context) (*Stats, error) {
+	fields, err := c.client.HGetAll(ctx, statsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get cache stats: %w", err)
+	}
+
+	stats := &Stats{Comixifiers: make(map[string]*Counters)}
+	for field, rawCount := range fields {
+		count, err := strconv.ParseInt(rawCount, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse cache stats field %s: %w", field, err)
+		}
+
+		i := strings.LastIndex(field, ":")
+		if i < 0 {
+			continue
+		}
+		comixifier, counter := field[:i], field[i+1:]
+		counters, ok := stats.Comixifiers[comixifier]
+		if !ok {
+			counters = &Counters{}
+			stats.Comixifiers[comixifier] = counters
+		}
+		switch counter {
+		case "hits":
+			counters.Hits += count
+			stats.Hits += count
+		case "misses":
+			counters.Misses += count
+			stats.Misses += count
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"comixifier/internal"
+	"testing"
+)
+
+func TestKey_Unit(t *testing.T) {
+	options := internal.Options{"style": "5", "model": "a b"}
+	key := Key("abc", "cutout", options)
+	expected := "result-cache:abc:cutout:model=a+b&style=5"
+	if key != expected {
+		t.Logf("key got: %s; expected: %s", key, expected)
+		t.FailNow()
+	}
+
+	if Key("abc", "VanceAI", options) == key || Key("abd", "cutout", options) == key {
+		t.Logf("keys of another comixifier or input are the same: %s", key)
+		t.FailNow()
+	}
+	if Key("abc", "cutout", internal.Options{"style": "6", "model": "a b"}) == key {
+		t.Logf("keys of other options are the same: %s", key)
+		t.FailNow()
+	}
+}
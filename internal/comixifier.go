@@ -1,7 +1,33 @@
 package internal
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type Comixifier interface {
-	Do(imgData io.Reader) (io.Reader, error)
+	Do(ctx context.Context, req *Request) (io.Reader, error)
+}
+
+// Request is an image to comixify with provider specific options.
+type Request struct {
+	Image io.Reader
+	// ContentType is a MIME type of the image detected by its content.
+	ContentType string
+	Options     Options
+}
+
+// Options are per-request provider settings by option name.
+type Options map[string]string
+
+func NewRequest(image io.Reader, contentType string, options Options) *Request {
+	if options == nil {
+		options = make(Options)
+	}
+
+	return &Request{
+		Image:       image,
+		ContentType: contentType,
+		Options:     options,
+	}
 }
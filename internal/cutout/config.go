@@ -0,0 +1,41 @@
+package cutout
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+var cfg = DefaultConfig()
+
+// Config is how the cutout.pro API is called.
+type Config struct {
+	ApiToken string        `long:"cutout-api-token" description:"token for making cutout.pro api requests" env:"CUTOUT_API_TOKEN" yaml:"apiToken"`
+	URL      string        `long:"cutout-api-url" description:"url of the cartoon selfie endpoint" env:"CUTOUT_API_URL" yaml:"url"`
+	Timeout  time.Duration `long:"cutout-timeout" description:"how long a transform by cutout.pro may take, 0 is unlimited" env:"CUTOUT_TIMEOUT" yaml:"timeout"`
+}
+
+// DefaultConfig calls the public cutout.pro API.
+func DefaultConfig() *Config {
+	return &Config{
+		URL:     "https://www.cutout.pro/api/v1/cartoonSelfie",
+		Timeout: 2 * time.Minute,
+	}
+}
+
+// Use sets the config transforms are run with.
+func Use(c *Config) {
+	cfg = c
+}
+
+// Validate checks the endpoint URL and the timeout. The token may be empty, transforms fail then.
+func (c *Config) Validate() error {
+	parsedURL, err := url.Parse(c.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return fmt.Errorf("cutout url must be an absolute http url, got %q", c.URL)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("cutout timeout must not be negative, got %s", c.Timeout)
+	}
+	return nil
+}
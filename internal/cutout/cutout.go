@@ -2,15 +2,46 @@ package cutout
 
 import (
 	"bytes"
+	"comixifier/internal"
+	"comixifier/internal/imaging"
+	"comixifier/internal/registry"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
-	"os"
+	"net/url"
 	"strings"
 )
 
+func init() {
+	registry.Register(&registry.Provider{
+		Name:          "cutout",
+		Description:   "Cartoon selfie by cutout.pro",
+		InputFormats:  imaging.InputFormats,
+		MaxInputSize:  15 << 20,
+		MaxResolution: 4096,
+		Credits:       1,
+		Options: []registry.Option{
+			{
+				Name:        "cartoonType",
+				Type:        registry.OptionTypeInteger,
+				Description: "cartoon style, see cartoonType of the cutout.pro cartoon selfie API",
+				Default:     defaultCartoonType,
+				Minimum:     registry.Int(1),
+				Maximum:     registry.Int(11),
+			},
+		},
+		New: func() internal.Comixifier {
+			return NewCutout()
+		},
+	})
+}
+
+const defaultCartoonType = "5"
+
 type Cutout struct {
 }
 
@@ -18,23 +49,27 @@ func NewCutout() *Cutout {
 	return &Cutout{}
 }
 
-func (c *Cutout) Do(imgData io.Reader) (io.Reader, error) {
+func (c *Cutout) Do(ctx context.Context, comixifyReq *internal.Request) (io.Reader, error) {
 	bodyBuf := new(bytes.Buffer)
 	bodyWriter := multipart.NewWriter(bodyBuf)
 
+	contentType := comixifyReq.ContentType
+	if contentType == "" {
+		contentType = "image/png"
+	}
 	h := make(textproto.MIMEHeader)
 	h.Set("Content-Disposition",
 		fmt.Sprintf(
 			`form-data; name="%s"; filename="%s"`,
-			"file", "in.png",
+			"file", "in"+imaging.Extension(contentType),
 		),
 	)
-	h.Set("Content-Type", "image/png")
+	h.Set("Content-Type", contentType)
 	fileWriter, err := bodyWriter.CreatePart(h)
 	if err != nil {
 		return nil, fmt.Errorf("create multipart section for image file: %w", err)
 	}
-	_, err = io.Copy(fileWriter, imgData)
+	_, err = io.Copy(fileWriter, comixifyReq.Image)
 	if err != nil {
 		return nil, fmt.Errorf("copy image file: %w", err)
 	}
@@ -43,9 +78,17 @@ func (c *Cutout) Do(imgData io.Reader) (io.Reader, error) {
 		return nil, fmt.Errorf("close multipart body: %w", err)
 	}
 
-	req, err := http.NewRequest(
+	cartoonType := comixifyReq.Options["cartoonType"]
+	if cartoonType == "" {
+		cartoonType = defaultCartoonType
+	}
+	query := url.Values{}
+	query.Set("cartoonType", cartoonType)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
-		"https://www.cutout.pro/api/v1/cartoonSelfie?cartoonType=5",
+		cfg.URL+"?"+query.Encode(),
 		bodyBuf,
 	)
 	if err != nil {
@@ -53,17 +96,72 @@ func (c *Cutout) Do(imgData io.Reader) (io.Reader, error) {
 	}
 
 	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
-	req.Header.Set("APIKEY", os.Getenv("CUTOUT_API_TOKEN"))
+	req.Header.Set("APIKEY", cfg.ApiToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	internal.ReportStage(ctx, "cartoonizing")
+	// The timeout covers reading the result too.
+	resp, err := (&http.Client{Timeout: cfg.Timeout}).Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("send request: %w", err)
 	}
-	if strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+	if resp.StatusCode != http.StatusOK || strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
 		defer resp.Body.Close()
 		errJsonData, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("cutout error: %s", string(errJsonData))
+		return nil, parseError(resp.StatusCode, errJsonData)
 	}
 
 	return resp.Body, nil
 }
+
+// Error codes of the cutout.pro API.
+const (
+	codeInvalidParams       = 1000
+	codeInsufficientBalance = 1001
+	codeInvalidApiKey       = 1002
+	codeInvalidContentType  = 1003
+	codeFileNotFound        = 1004
+)
+
+// errorBody is the JSON error of cutout.pro, which comes with HTTP 200 too.
+type errorBody struct {
+	Code json.Number `json:"code"`
+	Msg  string      `json:"msg"`
+}
+
+// parseError classifies the error of the response by its JSON code, and by the HTTP status
+// for codes which don't tell the kind. Messages are for people and never classify errors.
+func parseError(status int, errJsonData []byte) error {
+	body := &errorBody{}
+	if json.Unmarshal(errJsonData, body) != nil || body.Code == "" {
+		return classifyError(status, fmt.Errorf("cutout error: %s", string(errJsonData)))
+	}
+
+	err := fmt.Errorf("cutout error %s: %s", body.Code, body.Msg)
+	code, _ := body.Code.Int64()
+	switch code {
+	case codeInsufficientBalance:
+		return internal.NewProviderError(internal.ErrorKindQuota, err)
+	case codeInvalidApiKey:
+		return internal.NewProviderError(internal.ErrorKindAuth, err)
+	case codeInvalidParams, codeInvalidContentType, codeFileNotFound:
+		return internal.NewProviderError(internal.ErrorKindInput, err)
+	}
+	return classifyError(status, err)
+}
+
+// classifyError tells failures of the account and of the service from rejected images by the HTTP status.
+func classifyError(status int, err error) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return internal.NewProviderError(internal.ErrorKindAuth, err)
+	case status == http.StatusPaymentRequired || status == http.StatusTooManyRequests:
+		return internal.NewProviderError(internal.ErrorKindQuota, err)
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return internal.NewProviderError(internal.ErrorKindTimeout, err)
+	case status >= http.StatusInternalServerError:
+		return internal.NewProviderError(internal.ErrorKindUnavailable, err)
+	case status >= http.StatusBadRequest:
+		return internal.NewProviderError(internal.ErrorKindInput, err)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package cutout
+
+import (
+	"comixifier/internal"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCutout_Do_ErrorBody_Unit(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		body   string
+		kind   internal.ErrorKind
+	}{
+		{status: http.StatusOK, body: `{"code": 1001, "msg": "Insufficient balance", "data": null}`, kind: internal.ErrorKindQuota},
+		{status: http.StatusOK, body: `{"code": "1002", "msg": "APIKEY is invalid"}`, kind: internal.ErrorKindAuth},
+		{status: http.StatusOK, body: `{"code": 1003, "msg": "content-type error"}`, kind: internal.ErrorKindInput},
+		{status: http.StatusOK, body: `{"code": 4005, "msg": "insufficient face quality"}`, kind: ""},
+		{status: http.StatusOK, body: `{"code": 4006, "msg": "token of the image is not recognized"}`, kind: ""},
+		{status: http.StatusBadRequest, body: `{"code": 4005, "msg": "insufficient face quality"}`, kind: internal.ErrorKindInput},
+		{status: http.StatusPaymentRequired, body: `{"msg": "no credits"}`, kind: internal.ErrorKindQuota},
+		{status: http.StatusServiceUnavailable, body: `<html>down</html>`, kind: internal.ErrorKindUnavailable},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+			w.WriteHeader(test.status)
+			_, _ = w.Write([]byte(test.body))
+		}))
+		Use(&Config{URL: srv.URL, Timeout: time.Second})
+
+		_, err := NewCutout().Do(context.Background(), internal.NewRequest(strings.NewReader("img"), "image/png", nil))
+		srv.Close()
+		if err == nil {
+			t.Logf("error of %d %s got: nil; expected an error", test.status, test.body)
+			t.FailNow()
+		}
+		if kind := internal.ClassifyError(err); kind != test.kind {
+			t.Logf("kind of %d %s got: %q; expected: %q", test.status, test.body, kind, test.kind)
+			t.FailNow()
+		}
+	}
+	Use(DefaultConfig())
+}
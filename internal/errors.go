@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ErrorKind tells why a comixifier failed, so the transform can be handed to another one
+// unless the image itself is the problem.
+type ErrorKind string
+
+const (
+	ErrorKindQuota       ErrorKind = "quota"
+	ErrorKindAuth        ErrorKind = "auth"
+	ErrorKindTimeout     ErrorKind = "timeout"
+	ErrorKindUnavailable ErrorKind = "unavailable"
+	ErrorKindInput       ErrorKind = "input"
+)
+
+// Fallback reports whether another comixifier may succeed where one failed with the kind.
+func (k ErrorKind) Fallback() bool {
+	return k == ErrorKindQuota || k == ErrorKindAuth || k == ErrorKindTimeout || k == ErrorKindUnavailable
+}
+
+// ProviderError is an error of a comixifier classified by the comixifier.
+type ProviderError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func NewProviderError(kind ErrorKind, err error) *ProviderError {
+	return &ProviderError{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+func (e *ProviderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError returns the kind of the comixifier error. Errors not classified by comixifiers
+// are told by their cause, the empty kind means the cause is unknown.
+func ClassifyError(err error) ErrorKind {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Kind
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorKindTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorKindTimeout
+		}
+		return ErrorKindUnavailable
+	}
+	return ""
+}
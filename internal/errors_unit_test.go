@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestClassifyError_Unit(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{name: "classified", err: fmt.Errorf("comixify: %w", NewProviderError(ErrorKindQuota, errors.New("no credits"))), want: ErrorKindQuota},
+		{name: "deadline", err: fmt.Errorf("wait: %w", context.DeadlineExceeded), want: ErrorKindTimeout},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: ErrorKindUnavailable},
+		{name: "unknown", err: errors.New("got fatal job status"), want: ""},
+	}
+	for _, test := range tests {
+		if got := ClassifyError(test.err); got != test.want {
+			t.Logf("%s: kind got: %q; expected: %q", test.name, got, test.want)
+			t.FailNow()
+		}
+	}
+
+	if ErrorKindInput.Fallback() || !ErrorKindAuth.Fallback() {
+		t.Logf("fallback got: input %t, auth %t; expected: false, true", ErrorKindInput.Fallback(), ErrorKindAuth.Fallback())
+		t.FailNow()
+	}
+}
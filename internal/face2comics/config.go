@@ -0,0 +1,44 @@
+package face2comics
+
+import (
+	"fmt"
+	"time"
+)
+
+var cfg = DefaultConfig()
+
+// Config is the Telegram account which talks to the bot.
+type Config struct {
+	AppId   string `long:"face2comics-app-id" description:"telegram app id" env:"FACE2COMICS_APP_ID" yaml:"appId"`
+	AppHash string `long:"face2comics-app-hash" description:"telegram app hash" env:"FACE2COMICS_APP_HASH" yaml:"appHash"`
+	Phone   string `long:"face2comics-phone" description:"phone number of the telegram account" env:"FACE2COMICS_PHONE" yaml:"phone"`
+	// ReplyWait is how long the bot is given to reply before its messages are read.
+	ReplyWait time.Duration `long:"face2comics-reply-wait" description:"how long to wait for the bot reply" env:"FACE2COMICS_REPLY_WAIT" yaml:"replyWait"`
+	Timeout   time.Duration `long:"face2comics-timeout" description:"how long a transform by face2comics may take, 0 is unlimited" env:"FACE2COMICS_TIMEOUT" yaml:"timeout"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		ReplyWait: 30 * time.Second,
+		Timeout:   2 * time.Minute,
+	}
+}
+
+// Use sets the config transforms are run with.
+func Use(c *Config) {
+	cfg = c
+}
+
+// Validate checks the timeouts. The account may be empty, transforms fail then.
+func (c *Config) Validate() error {
+	if c.ReplyWait <= 0 {
+		return fmt.Errorf("face2comics reply wait must be positive, got %s", c.ReplyWait)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("face2comics timeout must not be negative, got %s", c.Timeout)
+	}
+	if c.Timeout > 0 && c.Timeout <= c.ReplyWait {
+		return fmt.Errorf("face2comics timeout %s must be longer than the reply wait %s", c.Timeout, c.ReplyWait)
+	}
+	return nil
+}
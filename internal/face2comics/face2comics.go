@@ -3,11 +3,15 @@ package face2comics
 import (
 	"bufio"
 	"bytes"
+	"comixifier/internal"
+	"comixifier/internal/imaging"
+	"comixifier/internal/registry"
 	"context"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,8 +22,22 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 )
 
+func init() {
+	registry.Register(&registry.Provider{
+		Name:          "face2comics",
+		Description:   "Comics portrait by the @face2comicsbot Telegram bot",
+		InputFormats:  imaging.InputFormats,
+		MaxInputSize:  10 << 20,
+		MaxResolution: 2560,
+		New: func() internal.Comixifier {
+			return NewFace2Comics()
+		},
+	})
+}
+
 type Face2Comics struct {
 }
 
@@ -27,31 +45,40 @@ func NewFace2Comics() *Face2Comics {
 	return &Face2Comics{}
 }
 
-func (f *Face2Comics) Do(imgData io.Reader) (io.Reader, error) {
-	imgFile, err := os.Create("in.png")
+func (f *Face2Comics) Do(ctx context.Context, req *internal.Request) (io.Reader, error) {
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "image/png"
+	}
+	imgFile, err := os.CreateTemp("", "in_*"+imaging.Extension(contentType))
 	if err != nil {
-		return nil, fmt.Errorf("create image file in.png: %w", err)
+		return nil, fmt.Errorf("create temp image file: %w", err)
 	}
+	defer os.Remove(imgFile.Name())
 	defer imgFile.Close()
-	_, err = io.Copy(imgFile, imgData)
+	_, err = io.Copy(imgFile, req.Image)
 	if err != nil {
 		return nil, fmt.Errorf("copy image data to file: %w", err)
 	}
 
-	appId := os.Getenv("FACE2COMICS_APP_ID")
-	if appId == "" {
-		return nil, fmt.Errorf("empty env FACE2COMICS_APP_ID")
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	if cfg.AppId == "" {
+		return nil, internal.NewProviderError(internal.ErrorKindAuth, fmt.Errorf("empty face2comics app id"))
 	}
-	err = os.Setenv("APP_ID", appId)
+	err = os.Setenv("APP_ID", cfg.AppId)
 	if err != nil {
 		return nil, fmt.Errorf("set env APP_ID: %w", err)
 	}
 
-	appHash := os.Getenv("FACE2COMICS_APP_HASH")
-	if appHash == "" {
-		return nil, fmt.Errorf("empty env FACE2COMICS_APP_HASH")
+	if cfg.AppHash == "" {
+		return nil, internal.NewProviderError(internal.ErrorKindAuth, fmt.Errorf("empty face2comics app hash"))
 	}
-	err = os.Setenv("APP_HASH", appHash)
+	err = os.Setenv("APP_HASH", cfg.AppHash)
 	if err != nil {
 		return nil, fmt.Errorf("set env APP_HASH: %w", err)
 	}
@@ -61,16 +88,13 @@ func (f *Face2Comics) Do(imgData io.Reader) (io.Reader, error) {
 		return nil, fmt.Errorf("create logger: %w", err)
 	}
 	defer func() { _ = log.Sync() }()
-	// No graceful shutdown.
-	ctx := context.Background()
 
-	phone := os.Getenv("FACE2COMICS_PHONE")
-	if phone == "" {
-		return nil, fmt.Errorf("empty env FACE2COMICS_PHONE")
+	if cfg.Phone == "" {
+		return nil, internal.NewProviderError(internal.ErrorKindAuth, fmt.Errorf("empty face2comics phone"))
 	}
 	// Setting up authentication flow helper based on terminal auth.
 	flow := auth.NewFlow(
-		termAuth{phone: phone},
+		termAuth{phone: cfg.Phone},
 		auth.SendCodeOptions{},
 	)
 
@@ -83,6 +107,7 @@ func (f *Face2Comics) Do(imgData io.Reader) (io.Reader, error) {
 
 	var resultImgData io.Reader
 	err = client.Run(ctx, func(ctx context.Context) error {
+		internal.ReportStage(ctx, "authorizing")
 		if err := client.Auth().IfNecessary(ctx, flow); err != nil {
 			return err
 		}
@@ -96,18 +121,26 @@ func (f *Face2Comics) Do(imgData io.Reader) (io.Reader, error) {
 		//}
 
 		// * Upload and send image
-		msgId, err := sendImage(ctx, client, log, "in.png")
+		internal.ReportStage(ctx, "sending")
+		msgId, err := sendImage(ctx, client, log, imgFile.Name())
 		if err != nil {
 			return fmt.Errorf("send image: %w", err)
 		}
 
 		log.Info("SEND IMAGE: SUCCESS")
+		internal.ReportStage(ctx, "waiting")
 
 		var resultImgBytes []byte
-		errChan := make(chan error)
+		errChan := make(chan error, 1)
 		go func() {
-			timer := time.NewTimer(30 * time.Second)
-			<-timer.C
+			timer := time.NewTimer(cfg.ReplyWait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				errChan <- ctx.Err()
+				return
+			case <-timer.C:
+			}
 
 			messagesGeneral, err := client.API().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
 				Peer: &tg.InputPeerUser{
@@ -186,7 +219,21 @@ func (f *Face2Comics) Do(imgData io.Reader) (io.Reader, error) {
 		return nil
 	})
 
-	return resultImgData, err
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return resultImgData, nil
+}
+
+// classifyError tells flood limits and revoked sessions of the Telegram account from other failures.
+func classifyError(err error) error {
+	if _, ok := tgerr.AsFloodWait(err); ok {
+		return internal.NewProviderError(internal.ErrorKindQuota, err)
+	}
+	if auth.IsUnauthorized(err) {
+		return internal.NewProviderError(internal.ErrorKindAuth, err)
+	}
+	return err
 }
 
 // noSignUp can be embedded to prevent signing up.
@@ -236,8 +283,9 @@ func sendImage(
 ) (int, error) {
 	img, err := os.Open(imgFilePath)
 	if err != nil {
-		return 0, fmt.Errorf("open in.png: %w", err)
+		return 0, fmt.Errorf("open %s: %w", imgFilePath, err)
 	}
+	defer img.Close()
 
 	nBytes, nChunks := int64(0), 0
 	r := bufio.NewReader(img)
@@ -292,7 +340,7 @@ func sendImage(
 			File: &tg.InputFile{
 				ID:    777888999000,
 				Parts: nChunks,
-				Name:  "in.png",
+				Name:  filepath.Base(imgFilePath),
 			},
 		},
 		Message:  "my message!",
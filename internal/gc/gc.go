@@ -0,0 +1,372 @@
+package gc
+
+import (
+	"comixifier/internal/state"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	reportKey = "gc-report"
+	lockKey   = "gc-lock"
+	// lockTTL frees the lock of a process which died while collecting.
+	lockTTL = 10 * time.Minute
+	// orphanAge is how old objects nothing refers to must be to be removed,
+	// so results being saved and inputs of transforms being created are left alone.
+	orphanAge = time.Hour
+	// uploadAge is how long presigned uploads wait to be used by a transform.
+	uploadAge = 24 * time.Hour
+	// reportedObjects limits how many removed objects the report lists.
+	reportedObjects = 1000
+)
+
+// Reasons objects are removed for.
+const (
+	ReasonExpired       = "expired"
+	ReasonUnreferenced  = "unreferenced"
+	ReasonStaleUpload   = "stale_upload"
+	ReasonOrphanedInput = "orphaned_input"
+)
+
+// Kinds of objects the collector knows.
+const (
+	kindResult = "result"
+	kindUpload = "upload"
+	kindInput  = "input"
+)
+
+var ErrRunning = errors.New("garbage collection is already running")
+
+const uuidPattern = `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`
+
+var (
+	// resultRegexp matches results, their renditions and variants, and comparison composites
+	// and their variants. The match is the key of the result without its extension.
+	resultRegexp = regexp.MustCompile(`^(img_` + uuidPattern + `_\d+_\d+|comparison_` + uuidPattern + `)`)
+	inputRegexp  = regexp.MustCompile(`^input_(` + uuidPattern + `)$`)
+	uploadRegexp = regexp.MustCompile(`^upload_` + uuidPattern + `$`)
+)
+
+// unlockScript frees the lock unless it has expired and been taken by another process.
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Removed is an object removed from the image storage.
+type Removed struct {
+	Key          string    `json:"key"`
+	Reason       string    `json:"reason"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Report tells what a garbage collection removed.
+type Report struct {
+	StartedAt    time.Time `json:"startedAt"`
+	FinishedAt   time.Time `json:"finishedAt"`
+	Scanned      int64     `json:"scanned"`
+	RemovedCount int64     `json:"removedCount"`
+	FreedBytes   int64     `json:"freedBytes"`
+	// Removed lists the first removed objects.
+	Removed []*Removed `json:"removed"`
+	Errors  []string   `json:"errors,omitempty"`
+}
+
+func (r *Report) add(removed *Removed) {
+	r.RemovedCount++
+	r.FreedBytes += removed.Size
+	if len(r.Removed) < reportedObjects {
+		r.Removed = append(r.Removed, removed)
+	}
+}
+
+// Collector removes objects of the image storage bucket which outlived their transforms: results
+// and their variants past their retention, results nothing refers to, unused uploads and inputs
+// of transforms which are gone. Objects it doesn't know are left alone.
+type Collector struct {
+	client     *redis.Client
+	transforms *state.Storage
+	images     *minio.Client
+	bucket     string
+	interval   time.Duration
+}
+
+func NewCollector(
+	client *redis.Client,
+	transforms *state.Storage,
+	images *minio.Client,
+	bucket string,
+	interval time.Duration,
+) *Collector {
+	return &Collector{
+		client:     client,
+		transforms: transforms,
+		images:     images,
+		bucket:     bucket,
+		interval:   interval,
+	}
+}
+
+// Run collects garbage every interval until ctx is done.
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := c.Collect(ctx)
+			if err != nil && !errors.Is(err, ErrRunning) && ctx.Err() == nil {
+				log.Printf("gc: collect garbage: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// Collect removes garbage of the bucket and saves the report of what it removed.
+// Only one process collects at a time, the others get ErrRunning. The collection
+// is cut short when the lock expires, so it never runs along with another one.
+func (c *Collector) Collect(ctx context.Context) (*Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, lockTTL)
+	defer cancel()
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := c.client.SetNX(ctx, lockKey, token, lockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("lock garbage collection: %w", err)
+	}
+	if !ok {
+		return nil, ErrRunning
+	}
+	defer func() {
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := unlockScript.Run(unlockCtx, c.client, []string{lockKey}, token).Err()
+		if err != nil {
+			log.Printf("gc: unlock garbage collection: %s\n", err.Error())
+		}
+	}()
+
+	now := time.Now()
+	report := &Report{
+		StartedAt: now.UTC(),
+		Removed:   make([]*Removed, 0),
+	}
+	keptUntil := make(map[string]time.Time)
+	claimed := make(map[string]bool)
+	// results are removed result bases, true unless some of their objects are left.
+	results := make(map[string]bool)
+	for object := range c.images.ListObjects(ctx, c.bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("list objects: %w", object.Err)
+		}
+		report.Scanned++
+
+		kind, name := classify(object.Key)
+		reason, err := c.reason(ctx, kind, name, object, now, keptUntil)
+		if err == nil && reason != "" && kind == kindResult {
+			reason, err = c.claim(ctx, name, reason, now, keptUntil, claimed)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("check %s: %s", object.Key, err.Error()))
+		}
+		if err == nil && reason != "" {
+			err = c.images.RemoveObject(ctx, c.bucket, object.Key, minio.RemoveObjectOptions{})
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("remove %s: %s", object.Key, err.Error()))
+			}
+		}
+		if kind == kindResult {
+			removed, seen := results[name]
+			results[name] = err == nil && reason != "" && (removed || !seen)
+		}
+		if err != nil || reason == "" {
+			continue
+		}
+		report.add(&Removed{
+			Key:          object.Key,
+			Reason:       reason,
+			Size:         object.Size,
+			LastModified: object.LastModified.UTC(),
+		})
+	}
+
+	forgotten := make([]string, 0, len(results))
+	for name, removed := range results {
+		if removed {
+			forgotten = append(forgotten, name)
+		}
+	}
+	err = c.transforms.ForgetObjects(ctx, forgotten)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	err = c.save(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("gc: removed %d of %d objects, freed %d bytes, %d errors\n",
+		report.RemovedCount, report.Scanned, report.FreedBytes, len(report.Errors),
+	)
+	return report, nil
+}
+
+// reason tells why the object of the kind and the name is garbage, it's empty for objects which are kept.
+// Expiry of results is looked up once for all of their variants.
+func (c *Collector) reason(
+	ctx context.Context,
+	kind string,
+	name string,
+	object minio.ObjectInfo,
+	now time.Time,
+	keptUntil map[string]time.Time,
+) (string, error) {
+	age := now.Sub(object.LastModified)
+	switch kind {
+	case kindResult:
+		until, ok := keptUntil[name]
+		if !ok {
+			var err error
+			until, err = c.transforms.KeptUntil(ctx, name)
+			if err != nil {
+				return "", err
+			}
+			keptUntil[name] = until
+		}
+		return resultReason(until, age, now), nil
+	case kindUpload:
+		if age < uploadAge {
+			return "", nil
+		}
+		return ReasonStaleUpload, nil
+	case kindInput:
+		if age < orphanAge {
+			return "", nil
+		}
+		_, err := c.transforms.Job(ctx, name)
+		if errors.Is(err, state.ErrNotFound) {
+			return ReasonOrphanedInput, nil
+		}
+		return "", err
+	default:
+		return "", nil
+	}
+}
+
+// claim marks the result with the base as collected before its objects are removed, so transforms
+// can't take the result over meanwhile. The result is kept if it was kept longer since its expiry was
+// looked up, the reason is empty then. Results are claimed once for all of their variants.
+func (c *Collector) claim(
+	ctx context.Context,
+	base string,
+	reason string,
+	now time.Time,
+	keptUntil map[string]time.Time,
+	claimed map[string]bool,
+) (string, error) {
+	if claimed[base] {
+		return reason, nil
+	}
+
+	ok, err := c.transforms.ClaimObject(ctx, base, now)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		// The expiry is looked up again for the rest of the variants.
+		delete(keptUntil, base)
+		return "", nil
+	}
+	claimed[base] = true
+	return reason, nil
+}
+
+// resultReason tells why the result kept until the time is garbage. Results which aren't kept
+// at all are removed once they are old enough to not be in the middle of saving.
+func resultReason(until time.Time, age time.Duration, now time.Time) string {
+	if until.IsZero() {
+		if age < orphanAge {
+			return ""
+		}
+		return ReasonUnreferenced
+	}
+	if until.After(now) {
+		return ""
+	}
+	return ReasonExpired
+}
+
+// classify returns the kind of the object and what it belongs to: the result base key for results,
+// their renditions and variants, and the transform id for inputs. The kind is empty for unknown objects.
+func classify(key string) (string, string) {
+	if match := resultRegexp.FindStringSubmatch(key); match != nil {
+		return kindResult, match[1]
+	}
+	if match := inputRegexp.FindStringSubmatch(key); match != nil {
+		return kindInput, match[1]
+	}
+	if uploadRegexp.MatchString(key) {
+		return kindUpload, key
+	}
+	return "", ""
+}
+
+func (c *Collector) save(ctx context.Context, report *Report) error {
+	jsonReport, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("marshal report to json: %w", err)
+	}
+
+	err = c.client.Set(ctx, reportKey, jsonReport, 0).Err()
+	if err != nil {
+		return fmt.Errorf("set report: %w", err)
+	}
+	return nil
+}
+
+// LastReport returns the report of the last garbage collection or nil if none has run yet.
+func (c *Collector) LastReport(ctx context.Context) (*Report, error) {
+	jsonReport, err := c.client.Get(ctx, reportKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get report: %w", err)
+	}
+
+	report := &Report{}
+	err = json.Unmarshal(jsonReport, report)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal report from json: %w", err)
+	}
+	return report, nil
+}
+
+func newToken() (string, error) {
+	random := make([]byte, 16)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", fmt.Errorf("generate lock token: %w", err)
+	}
+	return hex.EncodeToString(random), nil
+}
@@ -0,0 +1,69 @@
+package gc
+
+import (
+	"comixifier/internal/state"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassify_Unit(t *testing.T) {
+	id := "6f1c2a3e-0b4d-11ef-9a6b-0242ac120002"
+	result := fmt.Sprintf("img_%s_1714000000_123456.png", id)
+	base := state.ObjectBase(result)
+
+	for _, test := range []struct {
+		key  string
+		kind string
+		name string
+	}{
+		{key: result, kind: kindResult, name: base},
+		{key: base + "_thumbnail.png", kind: kindResult, name: base},
+		{key: base + "_thumbnail_q80.jpeg", kind: kindResult, name: base},
+		{key: base + ".webp", kind: kindResult, name: base},
+		{key: "comparison_" + id + ".png", kind: kindResult, name: "comparison_" + id},
+		{key: "comparison_" + id + "_q80.jpeg", kind: kindResult, name: "comparison_" + id},
+		{key: "input_" + id, kind: kindInput, name: id},
+		{key: "upload_" + id, kind: kindUpload, name: "upload_" + id},
+		{key: "input_" + id + "_copy", kind: "", name: ""},
+		{key: "img_client.png", kind: "", name: ""},
+		{key: "photos/" + result, kind: "", name: ""},
+	} {
+		kind, name := classify(test.key)
+		if kind != test.kind || name != test.name {
+			t.Logf("%s got: %q %q; expected: %q %q", test.key, kind, name, test.kind, test.name)
+			t.FailNow()
+		}
+	}
+}
+
+func TestResultReason_Unit(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		until  time.Time
+		age    time.Duration
+		reason string
+	}{
+		{until: now.Add(time.Hour), age: 48 * time.Hour, reason: ""},
+		{until: now.Add(-time.Second), age: 48 * time.Hour, reason: ReasonExpired},
+		{until: time.Time{}, age: time.Minute, reason: ""},
+		{until: time.Time{}, age: 2 * orphanAge, reason: ReasonUnreferenced},
+	} {
+		reason := resultReason(test.until, test.age, now)
+		if reason != test.reason {
+			t.Logf("reason of result kept until %s, %s old got: %q; expected: %q", test.until, test.age, reason, test.reason)
+			t.FailNow()
+		}
+	}
+}
+
+func TestReport_Add_Unit(t *testing.T) {
+	report := &Report{}
+	for i := 0; i < reportedObjects+5; i++ {
+		report.add(&Removed{Key: fmt.Sprintf("upload_%d", i), Reason: ReasonStaleUpload, Size: 10})
+	}
+	if report.RemovedCount != reportedObjects+5 || report.FreedBytes != 10*(reportedObjects+5) || len(report.Removed) != reportedObjects {
+		t.Logf("report got: %d removed, %d bytes freed, %d listed", report.RemovedCount, report.FreedBytes, len(report.Removed))
+		t.FailNow()
+	}
+}
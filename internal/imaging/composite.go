@@ -0,0 +1,82 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	compositePadding = 16
+	// labelScale enlarges the bitmap font, which is too small to read on photos.
+	labelScale  = 2
+	labelHeight = 13*labelScale + compositePadding
+)
+
+// Composite puts the images side by side scaled to the same height, with a label under each one.
+// The height is the smallest height of the images, limited by maxHeight.
+func Composite(images []image.Image, labels []string, maxHeight int) image.Image {
+	height := maxHeight
+	for _, img := range images {
+		if img.Bounds().Dy() < height {
+			height = img.Bounds().Dy()
+		}
+	}
+
+	tiles := make([]image.Image, 0, len(images))
+	width := compositePadding
+	for _, img := range images {
+		tileWidth := max1(img.Bounds().Dx() * height / img.Bounds().Dy())
+		tile := image.NewNRGBA(image.Rect(0, 0, tileWidth, height))
+		xdraw.CatmullRom.Scale(tile, tile.Bounds(), img, img.Bounds(), draw.Src, nil)
+		tiles = append(tiles, tile)
+		width += tileWidth + compositePadding
+	}
+
+	composite := image.NewRGBA(image.Rect(0, 0, width, compositePadding+height+labelHeight))
+	draw.Draw(composite, composite.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	x := compositePadding
+	for i, tile := range tiles {
+		tileRect := image.Rect(x, compositePadding, x+tile.Bounds().Dx(), compositePadding+height)
+		draw.Draw(composite, tileRect, tile, image.Point{}, draw.Over)
+		if i < len(labels) {
+			drawLabel(composite, labels[i], x, tile.Bounds().Dx(), tileRect.Max.Y+compositePadding/2)
+		}
+		x += tile.Bounds().Dx() + compositePadding
+	}
+
+	return composite
+}
+
+// drawLabel draws the text centered under a tile starting at x of the width.
+func drawLabel(dst draw.Image, text string, x int, width int, top int) {
+	face := basicfont.Face7x13
+	textWidth := font.MeasureString(face, text).Ceil()
+	if textWidth == 0 {
+		return
+	}
+
+	label := image.NewRGBA(image.Rect(0, 0, textWidth, face.Height))
+	draw.Draw(label, label.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	drawer := &font.Drawer{
+		Dst:  label,
+		Src:  image.NewUniform(color.Black),
+		Face: face,
+		Dot:  fixed.P(0, face.Ascent),
+	}
+	drawer.DrawString(text)
+
+	scaledWidth := textWidth * labelScale
+	left := x + (width-scaledWidth)/2
+	if left < x {
+		left = x
+	}
+	rect := image.Rect(left, top, left+scaledWidth, top+face.Height*labelScale)
+	xdraw.NearestNeighbor.Scale(dst, rect, label, label.Bounds(), draw.Over, nil)
+}
@@ -0,0 +1,30 @@
+package imaging
+
+import (
+	"image"
+	"testing"
+)
+
+func TestComposite_Unit(t *testing.T) {
+	images := []image.Image{
+		image.NewRGBA(image.Rect(0, 0, 400, 800)),
+		image.NewRGBA(image.Rect(0, 0, 300, 300)),
+	}
+
+	composite := Composite(images, []string{"cutout", "face2comics"}, 1024)
+	// Tiles are scaled to the smallest height: 150x300 and 300x300.
+	wantWidth := compositePadding + 150 + compositePadding + 300 + compositePadding
+	wantHeight := compositePadding + 300 + labelHeight
+	if composite.Bounds().Dx() != wantWidth || composite.Bounds().Dy() != wantHeight {
+		t.Logf("size got: %dx%d; expected: %dx%d",
+			composite.Bounds().Dx(), composite.Bounds().Dy(), wantWidth, wantHeight,
+		)
+		t.FailNow()
+	}
+
+	composite = Composite(images, nil, 100)
+	if composite.Bounds().Dy() != compositePadding+100+labelHeight {
+		t.Logf("height got: %d; expected: %d", composite.Bounds().Dy(), compositePadding+100+labelHeight)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,72 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// DefaultJPEGQuality is used when a client asks for JPEG without a quality.
+const DefaultJPEGQuality = 90
+
+// OutputFormats are formats results can be transcoded to.
+var OutputFormats = []string{"png", "jpeg", "gif"}
+
+// IsOutputFormat reports whether results can be transcoded to the format.
+func IsOutputFormat(format string) bool {
+	for _, f := range OutputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Transcode decodes the image and encodes it in the output format.
+// Quality is used by JPEG only.
+func Transcode(r io.Reader, format string, quality int) (*bytes.Buffer, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	out := new(bytes.Buffer)
+	err = Encode(out, img, format, quality)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Encode writes the image in the output format.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(w, img)
+	case "jpeg":
+		err = jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: quality})
+	case "gif":
+		err = gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("unsupported output format: %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", format, err)
+	}
+	return nil
+}
+
+// flatten puts the image on a white background, so transparent areas don't turn black in JPEG.
+func flatten(img image.Image) image.Image {
+	flat := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
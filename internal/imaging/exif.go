@@ -0,0 +1,100 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Values of the EXIF Orientation tag.
+const (
+	OrientationNormal     = 1
+	OrientationFlipH      = 2
+	OrientationRotate180  = 3
+	OrientationFlipV      = 4
+	OrientationTranspose  = 5
+	OrientationRotate90   = 6
+	OrientationTransverse = 7
+	OrientationRotate270  = 8
+)
+
+const orientationTag = 0x0112
+
+// Orientation returns the EXIF orientation of a JPEG image.
+// It returns OrientationNormal if the image has no or malformed EXIF data.
+func Orientation(jpeg []byte) int {
+	if len(jpeg) < 4 || jpeg[0] != 0xff || jpeg[1] != 0xd8 {
+		return OrientationNormal
+	}
+
+	for pos := 2; pos+4 <= len(jpeg); {
+		if jpeg[pos] != 0xff {
+			return OrientationNormal
+		}
+		marker := jpeg[pos+1]
+		if marker == 0xff {
+			// Fill byte before a marker.
+			pos++
+			continue
+		}
+		// Pixels follow the start of scan, metadata is always ahead of it.
+		if marker == 0xda || marker == 0xd9 {
+			return OrientationNormal
+		}
+
+		length := int(binary.BigEndian.Uint16(jpeg[pos+2:]))
+		if length < 2 || pos+2+length > len(jpeg) {
+			return OrientationNormal
+		}
+		segment := jpeg[pos+4 : pos+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+
+	return OrientationNormal
+}
+
+// tiffOrientation looks up the orientation tag in the first IFD of TIFF data.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return OrientationNormal
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return OrientationNormal
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return OrientationNormal
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return OrientationNormal
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return OrientationNormal
+		}
+		if order.Uint16(tiff[entry:]) != orientationTag {
+			continue
+		}
+
+		// The tag is a SHORT stored right in the value field.
+		orientation := int(order.Uint16(tiff[entry+8:]))
+		if orientation < OrientationNormal || orientation > OrientationRotate270 {
+			return OrientationNormal
+		}
+		return orientation
+	}
+
+	return OrientationNormal
+}
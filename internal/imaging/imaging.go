@@ -0,0 +1,163 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// sniffSize is enough to read dimensions of an image with big metadata ahead of pixels.
+const sniffSize = 512 << 10
+
+var (
+	ErrEmpty         = errors.New("image is empty")
+	ErrUnknownFormat = errors.New("data is not an image of a known format")
+	ErrTooLarge      = errors.New("image is too large")
+)
+
+// Info describes an image sent by a client.
+type Info struct {
+	Format      string `json:"format"`
+	ContentType string `json:"contentType"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int64  `json:"size"`
+	// SHA256 is a hex digest of the image bytes.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Limits are bounds of acceptable images.
+type Limits struct {
+	MinDimension int
+	MaxDimension int
+	MaxPixels    int64
+}
+
+// DefaultLimits reject images which are certainly not photos or too heavy to process.
+var DefaultLimits = Limits{
+	MinDimension: 32,
+	MaxDimension: 10000,
+	MaxPixels:    50_000_000,
+}
+
+// DimensionsError tells why dimensions of the image are out of limits.
+type DimensionsError struct {
+	Width  int
+	Height int
+	Reason string
+}
+
+func (e *DimensionsError) Error() string {
+	return fmt.Sprintf("image %dx%d %s", e.Width, e.Height, e.Reason)
+}
+
+// Sniff detects the format and dimensions of the image without decoding pixels.
+// The returned reader yields the whole image including the sniffed part.
+func Sniff(r io.Reader) (*Info, io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, err := br.Peek(sniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, nil, fmt.Errorf("read image head: %w", err)
+	}
+	if len(head) == 0 {
+		return nil, nil, ErrEmpty
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return nil, nil, ErrUnknownFormat
+	}
+
+	return &Info{
+		Format:      format,
+		ContentType: ContentType(format),
+		Width:       config.Width,
+		Height:      config.Height,
+	}, br, nil
+}
+
+// Check returns a DimensionsError if the image is out of limits.
+func (l Limits) Check(info *Info) error {
+	if info.Width < l.MinDimension || info.Height < l.MinDimension {
+		return &DimensionsError{
+			Width:  info.Width,
+			Height: info.Height,
+			Reason: fmt.Sprintf("is smaller than %dx%d", l.MinDimension, l.MinDimension),
+		}
+	}
+	if info.Width > l.MaxDimension || info.Height > l.MaxDimension {
+		return &DimensionsError{
+			Width:  info.Width,
+			Height: info.Height,
+			Reason: fmt.Sprintf("is larger than %dx%d", l.MaxDimension, l.MaxDimension),
+		}
+	}
+	if int64(info.Width)*int64(info.Height) > l.MaxPixels {
+		return &DimensionsError{
+			Width:  info.Width,
+			Height: info.Height,
+			Reason: fmt.Sprintf("has more than %d pixels", l.MaxPixels),
+		}
+	}
+	return nil
+}
+
+// ContentType returns a MIME type of the image format reported by the image package.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Extension returns a file extension for the MIME type of an image.
+func Extension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpeg"
+	case "image/gif":
+		return ".gif"
+	case "image/bmp":
+		return ".bmp"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
+
+// SizeReader counts bytes read and fails with ErrTooLarge after the limit.
+type SizeReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func NewSizeReader(r io.Reader, limit int64) *SizeReader {
+	return &SizeReader{r: r, limit: limit}
+}
+
+func (r *SizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.limit > 0 && r.n > r.limit {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// Size returns the number of bytes read.
+func (r *SizeReader) Size() int64 {
+	return r.n
+}
+
+// Exceeded reports whether more bytes than allowed were read.
+func (r *SizeReader) Exceeded() bool {
+	return r.limit > 0 && r.n > r.limit
+}
@@ -0,0 +1,105 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSniff_Unit(t *testing.T) {
+	imgBuf := new(bytes.Buffer)
+	err := png.Encode(imgBuf, image.NewRGBA(image.Rect(0, 0, 64, 48)))
+	if err != nil {
+		t.Logf("encode png: %s", err.Error())
+		t.FailNow()
+	}
+	imgBytes := imgBuf.Bytes()
+
+	info, r, err := Sniff(bytes.NewReader(imgBytes))
+	if err != nil {
+		t.Logf("error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+	if info.Format != "png" || info.ContentType != "image/png" || info.Width != 64 || info.Height != 48 {
+		t.Logf("info got: %+v; expected: png 64x48", info)
+		t.FailNow()
+	}
+	all, _ := io.ReadAll(r)
+	if !bytes.Equal(all, imgBytes) {
+		t.Logf("reader lost sniffed bytes: got %d bytes; expected: %d", len(all), len(imgBytes))
+		t.FailNow()
+	}
+
+	err = DefaultLimits.Check(info)
+	if err != nil {
+		t.Logf("check error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+
+	_, _, err = Sniff(strings.NewReader(""))
+	if !errors.Is(err, ErrEmpty) {
+		t.Logf("error got: %v; expected: %s", err, ErrEmpty)
+		t.FailNow()
+	}
+
+	_, _, err = Sniff(strings.NewReader("not an image"))
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Logf("error got: %v; expected: %s", err, ErrUnknownFormat)
+		t.FailNow()
+	}
+}
+
+func TestLimits_Check_Unit(t *testing.T) {
+	tests := []struct {
+		info    *Info
+		wantErr bool
+	}{
+		{info: &Info{Width: 31, Height: 100}, wantErr: true},
+		{info: &Info{Width: 100, Height: 10001}, wantErr: true},
+		{info: &Info{Width: 9000, Height: 9000}, wantErr: true},
+		{info: &Info{Width: 4000, Height: 3000}, wantErr: false},
+	}
+
+	for _, test := range tests {
+		err := DefaultLimits.Check(test.info)
+		if (err != nil) != test.wantErr {
+			t.Logf("%dx%d: error got: %v; expected error: %t", test.info.Width, test.info.Height, err, test.wantErr)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSizeReader_Unit(t *testing.T) {
+	r := NewSizeReader(strings.NewReader("0123456789"), 5)
+	_, err := io.ReadAll(r)
+	if !errors.Is(err, ErrTooLarge) || !r.Exceeded() {
+		t.Logf("error got: %v; expected: %s", err, ErrTooLarge)
+		t.FailNow()
+	}
+}
+
+func TestTranscode_Unit(t *testing.T) {
+	imgBuf := new(bytes.Buffer)
+	err := png.Encode(imgBuf, image.NewNRGBA(image.Rect(0, 0, 64, 48)))
+	if err != nil {
+		t.Logf("encode png: %s", err.Error())
+		t.FailNow()
+	}
+
+	for _, format := range OutputFormats {
+		out, err := Transcode(bytes.NewReader(imgBuf.Bytes()), format, DefaultJPEGQuality)
+		if err != nil {
+			t.Logf("%s: error got: %s; expected: <nil>", format, err.Error())
+			t.FailNow()
+		}
+		info, _, err := Sniff(out)
+		if err != nil || info.Format != format {
+			t.Logf("%s: sniffed got: %+v, %v; expected: %s", format, info, err, format)
+			t.FailNow()
+		}
+	}
+}
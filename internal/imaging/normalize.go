@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// InputFormats are MIME types of images which can be normalized for providers.
+var InputFormats = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/bmp",
+	"image/webp",
+}
+
+// Normalize prepares the image for a provider: applies the EXIF orientation,
+// downscales it to fit maxResolution on the longer side and converts it to PNG.
+// maxResolution of 0 keeps the original size.
+// PNG images of acceptable size are returned as is.
+func Normalize(r io.Reader, maxResolution int) (io.Reader, *Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read image: %w", err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	orientation := OrientationNormal
+	if format == "jpeg" {
+		orientation = Orientation(data)
+	}
+	width, height := scaledSize(img.Bounds().Dx(), img.Bounds().Dy(), maxResolution)
+	resized := width != img.Bounds().Dx() || height != img.Bounds().Dy()
+
+	if format == "png" && orientation == OrientationNormal && !resized {
+		return bytes.NewReader(data), &Info{
+			Format:      format,
+			ContentType: ContentType(format),
+			Width:       width,
+			Height:      height,
+			Size:        int64(len(data)),
+		}, nil
+	}
+
+	img = Resize(img, maxResolution)
+	img = Orient(img, orientation)
+
+	out := new(bytes.Buffer)
+	err = png.Encode(out, img)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode png: %w", err)
+	}
+
+	return out, &Info{
+		Format:      "png",
+		ContentType: ContentType("png"),
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Size:        int64(out.Len()),
+	}, nil
+}
+
+// scaledSize fits the size into maxResolution on the longer side keeping the aspect ratio.
+func scaledSize(width, height, maxResolution int) (int, int) {
+	if maxResolution <= 0 || (width <= maxResolution && height <= maxResolution) {
+		return width, height
+	}
+
+	if width >= height {
+		height = max1(height * maxResolution / width)
+		width = maxResolution
+	} else {
+		width = max1(width * maxResolution / height)
+		height = maxResolution
+	}
+	return width, height
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Orient turns the image so that it is displayed upright according to the EXIF orientation.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal || orientation > OrientationRotate270 {
+		return img
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	dstW, dstH := w, h
+	if orientation >= OrientationTranspose {
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case OrientationFlipH:
+				sx, sy = w-1-x, y
+			case OrientationRotate180:
+				sx, sy = w-1-x, h-1-y
+			case OrientationFlipV:
+				sx, sy = x, h-1-y
+			case OrientationTranspose:
+				sx, sy = y, x
+			case OrientationRotate90:
+				sx, sy = y, h-1-x
+			case OrientationTransverse:
+				sx, sy = w-1-y, h-1-x
+			case OrientationRotate270:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
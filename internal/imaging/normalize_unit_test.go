@@ -0,0 +1,115 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withOrientation inserts an EXIF segment with the orientation tag right after SOI of the JPEG.
+func withOrientation(jpegData []byte, orientation uint16) []byte {
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("MM")
+	_ = binary.Write(tiff, binary.BigEndian, uint16(42))
+	_ = binary.Write(tiff, binary.BigEndian, uint32(8))
+	_ = binary.Write(tiff, binary.BigEndian, uint16(1))
+	_ = binary.Write(tiff, binary.BigEndian, []uint16{orientationTag, 3})
+	_ = binary.Write(tiff, binary.BigEndian, uint32(1))
+	_ = binary.Write(tiff, binary.BigEndian, []uint16{orientation, 0})
+	_ = binary.Write(tiff, binary.BigEndian, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	out := append([]byte{}, jpegData[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, jpegData[2:]...)
+}
+
+func TestNormalize_Orientation_Unit(t *testing.T) {
+	// The left half is red, the right half is blue.
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 80; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 40 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	jpegBuf := new(bytes.Buffer)
+	err := jpeg.Encode(jpegBuf, img, &jpeg.Options{Quality: 95})
+	if err != nil {
+		t.Logf("encode jpeg: %s", err.Error())
+		t.FailNow()
+	}
+	data := withOrientation(jpegBuf.Bytes(), OrientationRotate90)
+
+	if o := Orientation(data); o != OrientationRotate90 {
+		t.Logf("orientation got: %d; expected: %d", o, OrientationRotate90)
+		t.FailNow()
+	}
+
+	r, info, err := Normalize(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Logf("error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+	if info.ContentType != "image/png" || info.Width != 40 || info.Height != 80 {
+		t.Logf("info got: %+v; expected: png 40x80", info)
+		t.FailNow()
+	}
+
+	out, err := png.Decode(r)
+	if err != nil {
+		t.Logf("decode result: %s", err.Error())
+		t.FailNow()
+	}
+	// Turned clockwise, the red half is on top.
+	red, _, blue, _ := out.At(20, 10).RGBA()
+	if red < blue {
+		t.Logf("top pixel got: red %d blue %d; expected red", red, blue)
+		t.FailNow()
+	}
+	red, _, blue, _ = out.At(20, 70).RGBA()
+	if blue < red {
+		t.Logf("bottom pixel got: red %d blue %d; expected blue", red, blue)
+		t.FailNow()
+	}
+}
+
+func TestNormalize_Downscale_Unit(t *testing.T) {
+	pngBuf := new(bytes.Buffer)
+	err := png.Encode(pngBuf, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	if err != nil {
+		t.Logf("encode png: %s", err.Error())
+		t.FailNow()
+	}
+
+	_, info, err := Normalize(bytes.NewReader(pngBuf.Bytes()), 50)
+	if err != nil {
+		t.Logf("error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+	if info.Width != 50 || info.Height != 25 {
+		t.Logf("size got: %dx%d; expected: 50x25", info.Width, info.Height)
+		t.FailNow()
+	}
+
+	r, info, err := Normalize(bytes.NewReader(pngBuf.Bytes()), 500)
+	if err != nil {
+		t.Logf("error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+	if info.Size != int64(pngBuf.Len()) || r.(*bytes.Reader).Len() != pngBuf.Len() {
+		t.Logf("small png was re-encoded: got %d bytes; expected: %d", info.Size, pngBuf.Len())
+		t.FailNow()
+	}
+}
@@ -0,0 +1,95 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// RenditionOriginal is the name of the result at its full size. It is always generated.
+const RenditionOriginal = "original"
+
+// Rendition is a named size variant of a result.
+type Rendition struct {
+	Name string
+	// MaxSize is the longest side of the rendition, 0 keeps the original size.
+	MaxSize int
+}
+
+// DefaultRenditions are generated unless configured otherwise.
+var DefaultRenditions = []Rendition{
+	{Name: "thumbnail", MaxSize: 256},
+	{Name: "medium", MaxSize: 1024},
+	{Name: RenditionOriginal},
+}
+
+// ParseRenditions parses a list like "thumbnail:256,medium:1024".
+// The original rendition is added if the list doesn't have it.
+func ParseRenditions(raw string) ([]Rendition, error) {
+	renditions := make([]Rendition, 0)
+	seen := make(map[string]bool)
+	hasOriginal := false
+
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		rendition := Rendition{Name: item}
+		if name, rawSize, ok := cut(item, ":"); ok {
+			size, err := strconv.Atoi(rawSize)
+			if err != nil || size < 1 {
+				return nil, fmt.Errorf("rendition %s: size must be a positive integer, got %q", name, rawSize)
+			}
+			rendition = Rendition{Name: name, MaxSize: size}
+		}
+		if rendition.Name == "" {
+			return nil, fmt.Errorf("rendition %q: empty name", item)
+		}
+		if seen[rendition.Name] {
+			return nil, fmt.Errorf("rendition %s is listed twice", rendition.Name)
+		}
+		if rendition.Name == RenditionOriginal {
+			if rendition.MaxSize != 0 {
+				return nil, fmt.Errorf("rendition %s can't have a size", RenditionOriginal)
+			}
+			hasOriginal = true
+		} else if rendition.MaxSize == 0 {
+			return nil, fmt.Errorf("rendition %s: size is required", rendition.Name)
+		}
+
+		seen[rendition.Name] = true
+		renditions = append(renditions, rendition)
+	}
+
+	if !hasOriginal {
+		renditions = append(renditions, Rendition{Name: RenditionOriginal})
+	}
+	return renditions, nil
+}
+
+// cut is strings.Cut, which is not available in go 1.17.
+func cut(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Resize downscales the image to fit maxSize on the longer side keeping the aspect ratio.
+// Smaller images are returned as is.
+func Resize(img image.Image, maxSize int) image.Image {
+	width, height := scaledSize(img.Bounds().Dx(), img.Bounds().Dy(), maxSize)
+	if width == img.Bounds().Dx() && height == img.Bounds().Dy() {
+		return img
+	}
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return scaled
+}
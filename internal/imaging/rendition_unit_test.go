@@ -0,0 +1,52 @@
+package imaging
+
+import (
+	"image"
+	"testing"
+)
+
+func TestParseRenditions_Unit(t *testing.T) {
+	renditions, err := ParseRenditions("thumbnail:128, large:2048")
+	if err != nil {
+		t.Logf("error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+	expected := []Rendition{
+		{Name: "thumbnail", MaxSize: 128},
+		{Name: "large", MaxSize: 2048},
+		{Name: RenditionOriginal},
+	}
+	if len(renditions) != len(expected) {
+		t.Logf("renditions got: %+v; expected: %+v", renditions, expected)
+		t.FailNow()
+	}
+	for i := range expected {
+		if renditions[i] != expected[i] {
+			t.Logf("renditions got: %+v; expected: %+v", renditions, expected)
+			t.FailNow()
+		}
+	}
+
+	for _, raw := range []string{"thumbnail", "thumbnail:0", "small:10,small:20", "original:100"} {
+		_, err = ParseRenditions(raw)
+		if err == nil {
+			t.Logf("%q: error got: <nil>; expected error", raw)
+			t.FailNow()
+		}
+	}
+}
+
+func TestResize_Unit(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 600))
+
+	resized := Resize(img, 100)
+	if resized.Bounds().Dx() != 50 || resized.Bounds().Dy() != 100 {
+		t.Logf("size got: %v; expected: 50x100", resized.Bounds().Size())
+		t.FailNow()
+	}
+
+	if Resize(img, 1000) != image.Image(img) {
+		t.Logf("small image was resized")
+		t.FailNow()
+	}
+}
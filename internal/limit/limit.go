@@ -0,0 +1,317 @@
+package limit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	limitsKey      = "rate-limits"
+	counterPrefix  = "rate-limit:"
+	keyPrefix      = "key:"
+	providerPrefix = "provider:"
+	// DefaultKeyScope applies to keys without their own limits.
+	DefaultKeyScope = keyPrefix + "default"
+)
+
+var ErrLimitsNotFound = errors.New("limits not found")
+
+// takeScript counts a transform in every window unless one of them is used up, so concurrent
+// transforms never overrun a limit. KEYS are window counters, ARGV are the limit, the TTL and
+// whether to count for each counter, windows which are only checked report the count they would have.
+// It returns {1, counts...} or {0, index of the used up counter, its count}.
+var takeScript = redis.NewScript(`
+for i, key in ipairs(KEYS) do
+	local count = tonumber(redis.call('GET', key) or '0')
+	if count >= tonumber(ARGV[i*3-2]) then
+		return {0, i, count}
+	end
+end
+local counts = {1}
+for i, key in ipairs(KEYS) do
+	if ARGV[i*3] == '1' then
+		counts[i+1] = redis.call('INCR', key)
+		redis.call('PEXPIRE', key, ARGV[i*3-1])
+	else
+		counts[i+1] = tonumber(redis.call('GET', key) or '0') + 1
+	end
+end
+return counts
+`)
+
+// refundScript uncounts a transform from the window counters which are still there.
+var refundScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if tonumber(redis.call('GET', key) or '0') > 0 then
+		redis.call('DECR', key)
+	end
+end
+return 0
+`)
+
+// Limits are how many transforms may be started in a window, zero is unlimited.
+type Limits struct {
+	PerMinute int64 `json:"perMinute,omitempty"`
+	PerDay    int64 `json:"perDay,omitempty"`
+	PerMonth  int64 `json:"perMonth,omitempty"`
+}
+
+// Decision tells whether a transform may start and how much is left of the limit closest to be used up.
+type Decision struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+	// Scope is the key or the provider the limit belongs to.
+	Scope string
+	// counters are the window counters the allowed transform was counted in.
+	counters []string
+}
+
+// RetryAfter is how long to wait until the limit is reset.
+func (d *Decision) RetryAfter(now time.Time) time.Duration {
+	if d.Reset.Before(now) {
+		return 0
+	}
+	return d.Reset.Sub(now)
+}
+
+// window is a limit of a scope in the current window.
+type window struct {
+	scope   string
+	counter string
+	limit   int64
+	reset   time.Time
+	// checked windows are not counted.
+	checked bool
+}
+
+// Limiter enforces limits of API keys and providers with counters in redis.
+type Limiter struct {
+	client *redis.Client
+}
+
+func NewLimiter(client *redis.Client) *Limiter {
+	return &Limiter{client: client}
+}
+
+func KeyScope(keyId string) string {
+	return keyPrefix + keyId
+}
+
+func ProviderScope(name string) string {
+	return providerPrefix + name
+}
+
+// Take counts a transform of the key and checks that the provider isn't used up, the provider is
+// counted by TakeProvider once the transform calls it. Limits of the key are the default ones unless
+// it has its own. The empty key id is limited by provider limits only. It returns nil if nothing is limited.
+func (l *Limiter) Take(ctx context.Context, keyId string, provider string, now time.Time) (*Decision, error) {
+	return l.take(ctx, keyId, provider, false, now)
+}
+
+// TakeProvider counts a call of the provider. It returns nil if the provider isn't limited.
+func (l *Limiter) TakeProvider(ctx context.Context, provider string, now time.Time) (*Decision, error) {
+	return l.take(ctx, "", provider, true, now)
+}
+
+func (l *Limiter) take(ctx context.Context, keyId string, provider string, countProvider bool, now time.Time) (*Decision, error) {
+	scopes := []string{ProviderScope(provider)}
+	if keyId != "" {
+		scopes = append(scopes, KeyScope(keyId), DefaultKeyScope)
+	}
+	rawLimits, err := l.client.HMGet(ctx, limitsKey, scopes...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get limits: %w", err)
+	}
+
+	var windows []*window
+	for i, rawScopeLimits := range rawLimits {
+		if rawScopeLimits == nil {
+			continue
+		}
+		scope := scopes[i]
+		if scope == DefaultKeyScope {
+			// Own limits of the key replace the default ones.
+			if rawLimits[1] != nil {
+				continue
+			}
+			scope = KeyScope(keyId)
+		}
+
+		limits := &Limits{}
+		err = json.Unmarshal([]byte(rawScopeLimits.(string)), limits)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal limits of %s from json: %w", scope, err)
+		}
+		scoped := scopeWindows(scope, limits, now)
+		for _, w := range scoped {
+			w.checked = i == 0 && !countProvider
+		}
+		windows = append(windows, scoped...)
+	}
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(windows))
+	args := make([]interface{}, 0, 3*len(windows))
+	var counters []string
+	for _, w := range windows {
+		keys = append(keys, w.counter)
+		count := 1
+		if w.checked {
+			count = 0
+		} else {
+			counters = append(counters, w.counter)
+		}
+		args = append(args, w.limit, w.reset.Sub(now).Milliseconds()+time.Second.Milliseconds(), count)
+	}
+	res, err := takeScript.Run(ctx, l.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("take limits: %w", err)
+	}
+
+	if res[0] == 0 {
+		w := windows[res[1]-1]
+		return &Decision{
+			Allowed:   false,
+			Limit:     w.limit,
+			Remaining: 0,
+			Reset:     w.reset,
+			Scope:     w.scope,
+		}, nil
+	}
+	decision := closest(windows, res[1:])
+	decision.counters = counters
+	return decision, nil
+}
+
+// Refund uncounts the transform allowed by the decision, which didn't start after all.
+func (l *Limiter) Refund(ctx context.Context, decision *Decision) error {
+	if decision == nil || len(decision.counters) == 0 {
+		return nil
+	}
+
+	err := refundScript.Run(ctx, l.client, decision.counters).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("refund limits: %w", err)
+	}
+	return nil
+}
+
+// closest returns the decision of the window with the fewest transforms left.
+func closest(windows []*window, counts []int64) *Decision {
+	var decision *Decision
+	for i, w := range windows {
+		remaining := w.limit - counts[i]
+		if remaining < 0 {
+			remaining = 0
+		}
+		if decision == nil || remaining < decision.Remaining {
+			decision = &Decision{
+				Allowed:   true,
+				Limit:     w.limit,
+				Remaining: remaining,
+				Reset:     w.reset,
+				Scope:     w.scope,
+			}
+		}
+	}
+	return decision
+}
+
+// scopeWindows returns the limited windows of the scope which contain now.
+func scopeWindows(scope string, limits *Limits, now time.Time) []*window {
+	now = now.UTC()
+	minute := now.Truncate(time.Minute)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var windows []*window
+	if limits.PerMinute > 0 {
+		windows = append(windows, &window{
+			scope:   scope,
+			counter: fmt.Sprintf("%s%s:minute:%d", counterPrefix, scope, minute.Unix()),
+			limit:   limits.PerMinute,
+			reset:   minute.Add(time.Minute),
+		})
+	}
+	if limits.PerDay > 0 {
+		windows = append(windows, &window{
+			scope:   scope,
+			counter: fmt.Sprintf("%s%s:day:%s", counterPrefix, scope, day.Format("20060102")),
+			limit:   limits.PerDay,
+			reset:   day.AddDate(0, 0, 1),
+		})
+	}
+	if limits.PerMonth > 0 {
+		windows = append(windows, &window{
+			scope:   scope,
+			counter: fmt.Sprintf("%s%s:month:%s", counterPrefix, scope, month.Format("200601")),
+			limit:   limits.PerMonth,
+			reset:   month.AddDate(0, 1, 0),
+		})
+	}
+	return windows
+}
+
+// All returns limits by scope.
+func (l *Limiter) All(ctx context.Context) (map[string]*Limits, error) {
+	rawLimits, err := l.client.HGetAll(ctx, limitsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get limits: %w", err)
+	}
+
+	all := make(map[string]*Limits, len(rawLimits))
+	for scope, rawScopeLimits := range rawLimits {
+		limits := &Limits{}
+		err = json.Unmarshal([]byte(rawScopeLimits), limits)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal limits of %s from json: %w", scope, err)
+		}
+		all[scope] = limits
+	}
+	return all, nil
+}
+
+// Set replaces limits of the scope. They apply to the next transform, counters are kept.
+func (l *Limiter) Set(ctx context.Context, scope string, limits *Limits) error {
+	jsonLimits, err := json.Marshal(limits)
+	if err != nil {
+		return fmt.Errorf("marshal limits to json: %w", err)
+	}
+
+	err = l.client.HSet(ctx, limitsKey, scope, jsonLimits).Err()
+	if err != nil {
+		return fmt.Errorf("set limits: %w", err)
+	}
+	return nil
+}
+
+// Delete removes limits of the scope.
+func (l *Limiter) Delete(ctx context.Context, scope string) error {
+	n, err := l.client.HDel(ctx, limitsKey, scope).Result()
+	if err != nil {
+		return fmt.Errorf("delete limits: %w", err)
+	}
+	if n == 0 {
+		return ErrLimitsNotFound
+	}
+	return nil
+}
+
+// SplitScope returns the kind of the scope, "key" or "provider", and its name.
+func SplitScope(scope string) (string, string) {
+	parts := strings.SplitN(scope, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,80 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScopeWindows_Unit(t *testing.T) {
+	now := time.Date(2024, time.December, 31, 23, 59, 30, 0, time.UTC)
+	windows := scopeWindows(KeyScope("k"), &Limits{PerMinute: 10, PerMonth: 1000}, now)
+	if len(windows) != 2 {
+		t.Logf("windows got: %d; expected: 2", len(windows))
+		t.FailNow()
+	}
+
+	minute, month := windows[0], windows[1]
+	if minute.limit != 10 || !minute.reset.Equal(time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Logf("minute window got: %d until %s", minute.limit, minute.reset)
+		t.FailNow()
+	}
+	if month.counter != "rate-limit:key:k:month:202412" || !month.reset.Equal(time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Logf("month window got: %s until %s", month.counter, month.reset)
+		t.FailNow()
+	}
+
+	day := scopeWindows(ProviderScope("VanceAI"), &Limits{PerDay: 5}, now.Add(time.Minute))[0]
+	if day.counter != "rate-limit:provider:VanceAI:day:20250101" || !day.reset.Equal(time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Logf("day window got: %s until %s", day.counter, day.reset)
+		t.FailNow()
+	}
+
+	if len(scopeWindows(KeyScope("k"), &Limits{}, now)) != 0 {
+		t.Logf("zero limits have windows")
+		t.FailNow()
+	}
+}
+
+func TestClosest_Unit(t *testing.T) {
+	now := time.Now()
+	windows := []*window{
+		{scope: "key:k", limit: 10, reset: now.Add(time.Minute)},
+		{scope: "provider:VanceAI", limit: 100, reset: now.Add(time.Hour)},
+	}
+	decision := closest(windows, []int64{3, 98})
+	if !decision.Allowed || decision.Scope != "provider:VanceAI" || decision.Limit != 100 || decision.Remaining != 2 {
+		t.Logf("decision got: %+v; expected 2 of 100 left for VanceAI", decision)
+		t.FailNow()
+	}
+
+	if decision.RetryAfter(now) != time.Hour || decision.RetryAfter(now.Add(2*time.Hour)) != 0 {
+		t.Logf("retry after got: %s, %s", decision.RetryAfter(now), decision.RetryAfter(now.Add(2*time.Hour)))
+		t.FailNow()
+	}
+}
+
+func TestSplitScope_Unit(t *testing.T) {
+	kind, name := SplitScope(ProviderScope("face2comics"))
+	if kind != "provider" || name != "face2comics" {
+		t.Logf("scope got: %s %s", kind, name)
+		t.FailNow()
+	}
+	kind, name = SplitScope(DefaultKeyScope)
+	if kind != "key" || name != "default" {
+		t.Logf("scope got: %s %s", kind, name)
+		t.FailNow()
+	}
+}
+
+func TestLimiter_Refund_Unit(t *testing.T) {
+	// Nothing was counted for transforms which weren't limited, so nothing is sent to redis.
+	l := NewLimiter(nil)
+	for _, decision := range []*Decision{nil, {Allowed: true}} {
+		err := l.Refund(context.Background(), decision)
+		if err != nil {
+			t.Logf("refund of %+v got: %s; expected no error", decision, err.Error())
+			t.FailNow()
+		}
+	}
+}
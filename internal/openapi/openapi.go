@@ -0,0 +1,134 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+var validator = mustNewValidator(spec)
+
+// Spec returns the OpenAPI document of the server API.
+func Spec() []byte {
+	return spec
+}
+
+type document struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters    map[string]*parameter   `json:"parameters"`
+		RequestBodies map[string]*requestBody `json:"requestBodies"`
+		Schemas       map[string]*Schema      `json:"schemas"`
+	} `json:"components"`
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Ref      string                `json:"$ref"`
+	Required bool                  `json:"required"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type operation struct {
+	Parameters  []*parameter `json:"parameters"`
+	RequestBody *requestBody `json:"requestBody"`
+}
+
+// Schema is the subset of the OpenAPI schema object used by the document.
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Enum       []interface{}      `json:"enum"`
+	MinLength  *int               `json:"minLength"`
+	MaxLength  *int               `json:"maxLength"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Items      *Schema            `json:"items"`
+}
+
+// route is a path template of the document with its operations by http method.
+type route struct {
+	segments   []string
+	operations map[string]*operation
+}
+
+func parse(rawSpec []byte) ([]*route, map[string]*Schema, error) {
+	doc := &document{}
+	err := json.Unmarshal(rawSpec, doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal document from json: %w", err)
+	}
+
+	var routes []*route
+	for path, item := range doc.Paths {
+		var common []*parameter
+		if rawParams, ok := item["parameters"]; ok {
+			err = json.Unmarshal(rawParams, &common)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unmarshal parameters of %s: %w", path, err)
+			}
+		}
+
+		r := &route{
+			segments:   strings.Split(strings.Trim(path, "/"), "/"),
+			operations: make(map[string]*operation),
+		}
+		for method, rawOp := range item {
+			if method == "parameters" {
+				continue
+			}
+
+			op := &operation{}
+			err = json.Unmarshal(rawOp, op)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unmarshal operation %s %s: %w", method, path, err)
+			}
+
+			op.Parameters = append(common, op.Parameters...)
+			for i, param := range op.Parameters {
+				if param.Ref != "" {
+					resolved, ok := doc.Components.Parameters[refName(param.Ref)]
+					if !ok {
+						return nil, nil, fmt.Errorf("unknown parameter %s in %s %s", param.Ref, method, path)
+					}
+					op.Parameters[i] = resolved
+				}
+			}
+			if op.RequestBody != nil && op.RequestBody.Ref != "" {
+				resolved, ok := doc.Components.RequestBodies[refName(op.RequestBody.Ref)]
+				if !ok {
+					return nil, nil, fmt.Errorf("unknown request body %s in %s %s", op.RequestBody.Ref, method, path)
+				}
+				op.RequestBody = resolved
+			}
+
+			r.operations[strings.ToUpper(method)] = op
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, doc.Components.Schemas, nil
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
@@ -0,0 +1,355 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxJSONBodySize = 1 << 20
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ValidationError tells which part of the request doesn't match the document.
+type ValidationError struct {
+	Location string
+	Message  string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Location + ": " + e.Message
+}
+
+// Validator checks requests against the OpenAPI document.
+type Validator struct {
+	routes  []*route
+	schemas map[string]*Schema
+}
+
+func NewValidator(rawSpec []byte) (*Validator, error) {
+	routes, schemas, err := parse(rawSpec)
+	if err != nil {
+		return nil, fmt.Errorf("parse document: %w", err)
+	}
+
+	return &Validator{
+		routes:  routes,
+		schemas: schemas,
+	}, nil
+}
+
+func mustNewValidator(rawSpec []byte) *Validator {
+	v, err := NewValidator(rawSpec)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: %s", err.Error()))
+	}
+	return v
+}
+
+// Validate checks the request against the server API document.
+func Validate(r *http.Request) error {
+	return validator.Validate(r)
+}
+
+// Validate checks parameters and the JSON body of the request. Requests to paths
+// or with methods missing in the document are left to the handlers.
+// A JSON body is read in full and replaced, so handlers can read it again.
+func (v *Validator) Validate(r *http.Request) error {
+	op, pathParams := v.find(r.Method, r.URL.Path)
+	if op == nil {
+		return nil
+	}
+
+	for _, param := range op.Parameters {
+		var value string
+		var present bool
+		switch param.In {
+		case "path":
+			value, present = pathParams[param.Name]
+		case "query":
+			present = r.URL.Query().Has(param.Name)
+			value = r.URL.Query().Get(param.Name)
+		case "header":
+			value = r.Header.Get(param.Name)
+			present = value != ""
+		default:
+			continue
+		}
+
+		location := param.In + " parameter " + param.Name
+		if !present {
+			if param.Required {
+				return &ValidationError{Location: location, Message: "is required"}
+			}
+			continue
+		}
+
+		err := v.validateParam(param.Schema, value)
+		if err != nil {
+			return &ValidationError{Location: location, Message: err.Error()}
+		}
+	}
+
+	if op.RequestBody != nil {
+		return v.validateBody(op.RequestBody, r)
+	}
+	return nil
+}
+
+func (v *Validator) find(method string, path string) (*operation, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for _, route := range v.routes {
+		params, ok := match(route.segments, segments)
+		if !ok {
+			continue
+		}
+		return route.operations[method], params
+	}
+	return nil, nil
+}
+
+func match(template []string, segments []string) (map[string]string, bool) {
+	if len(template) != len(segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, t := range template {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[t[1:len(t)-1]] = segments[i]
+			continue
+		}
+		if t != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (v *Validator) validateBody(body *requestBody, r *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		if body.Required {
+			return &ValidationError{Location: "request body", Message: "is required"}
+		}
+		return nil
+	}
+
+	// Legacy clients don't always set Content-Type, so only JSON bodies are checked.
+	mt := findMediaType(body.Content, r.Header.Get("Content-Type"))
+	if mt == nil || mt.Schema == nil {
+		return nil
+	}
+
+	rawBody, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBodySize+1))
+	if err != nil {
+		return &ValidationError{Location: "request body", Message: "read: " + err.Error()}
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(rawBody))
+
+	if len(rawBody) > maxJSONBodySize {
+		return &ValidationError{Location: "request body", Message: "is too large"}
+	}
+	if len(rawBody) == 0 {
+		if body.Required {
+			return &ValidationError{Location: "request body", Message: "is required"}
+		}
+		return nil
+	}
+
+	var value interface{}
+	err = json.Unmarshal(rawBody, &value)
+	if err != nil {
+		return &ValidationError{Location: "request body", Message: "is not valid json: " + err.Error()}
+	}
+
+	err = v.validateValue(mt.Schema, value, "")
+	if err != nil {
+		return &ValidationError{Location: "request body", Message: err.Error()}
+	}
+	return nil
+}
+
+// findMediaType returns the JSON media type the request body must match, if any.
+func findMediaType(content map[string]*mediaType, contentType string) *mediaType {
+	jsonType, ok := content["application/json"]
+	if !ok {
+		return nil
+	}
+
+	if contentType == "" {
+		if len(content) == 1 {
+			return jsonType
+		}
+		return nil
+	}
+
+	parsed, _, err := mime.ParseMediaType(contentType)
+	if err != nil || parsed != "application/json" {
+		if len(content) == 1 {
+			return jsonType
+		}
+		return nil
+	}
+	return jsonType
+}
+
+func (v *Validator) validateParam(schema *Schema, value string) error {
+	schema = v.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		return v.validateValue(schema, float64(n), "")
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		return v.validateValue(schema, n, "")
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		return v.validateValue(schema, b, "")
+	default:
+		return v.validateValue(schema, value, "")
+	}
+}
+
+// validateValue checks a decoded JSON value. Field is a dotted path to the value used in errors.
+func (v *Validator) validateValue(schema *Schema, value interface{}, field string) error {
+	schema = v.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+
+	fail := func(format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		if field == "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("%s: %s", field, msg)
+	}
+
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, e := range schema.Enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("must be one of %v", schema.Enum)
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				return fail("%s is required", name)
+			}
+		}
+		for name, propSchema := range schema.Properties {
+			propValue, ok := obj[name]
+			if !ok {
+				continue
+			}
+			err := v.validateValue(propSchema, propValue, joinField(field, name))
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		for i, item := range items {
+			err := v.validateValue(schema.Items, item, joinField(field, strconv.Itoa(i)))
+			if err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if schema.MinLength != nil && len(str) < *schema.MinLength {
+			return fail("must be at least %d characters long", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && len(str) > *schema.MaxLength {
+			return fail("must be at most %d characters long", *schema.MaxLength)
+		}
+		switch schema.Format {
+		case "uuid":
+			if !uuidRegexp.MatchString(str) {
+				return fail("must be a uuid")
+			}
+		case "uri":
+			u, err := url.Parse(str)
+			if err != nil || !u.IsAbs() {
+				return fail("must be an absolute uri")
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return fail("must be a %s", schema.Type)
+		}
+		if schema.Type == "integer" && n != float64(int64(n)) {
+			return fail("must be an integer")
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			return fail("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			return fail("must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	}
+
+	return nil
+}
+
+func (v *Validator) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = v.schemas[refName(schema.Ref)]
+	}
+	return schema
+}
+
+func joinField(field string, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
@@ -0,0 +1,126 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidate_Unit(t *testing.T) {
+	type testCase struct {
+		name    string
+		prepare func() *http.Request
+		wantErr string
+	}
+	tests := []testCase{
+		{
+			name: "correct progress",
+			prepare: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/progress",
+					strings.NewReader(`{"transformId": "0b8e3c2e-3f4c-11ed-b878-0242ac120002"}`))
+			},
+		},
+		{
+			name: "progress without transformId",
+			prepare: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/progress", strings.NewReader(`{}`))
+			},
+			wantErr: "request body: transformId is required",
+		},
+		{
+			name: "progress with malformed transformId",
+			prepare: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/progress", strings.NewReader(`{"transformId": 1}`))
+			},
+			wantErr: "request body: transformId: must be a string",
+		},
+		{
+			name: "progress with invalid json",
+			prepare: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/progress", strings.NewReader(`{`))
+			},
+			wantErr: "request body: is not valid json: unexpected end of JSON input",
+		},
+		{
+			name: "transform without comixifier",
+			prepare: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/transform", strings.NewReader("image"))
+			},
+			wantErr: "header parameter Comixifier-Name: is required",
+		},
+		{
+			name: "transform without image",
+			prepare: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/transform", nil)
+				r.Header.Set("Comixifier-Name", "cutout")
+				return r
+			},
+			wantErr: "request body: is required",
+		},
+		{
+			name: "transform with image of any content type",
+			prepare: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/transform", strings.NewReader("image"))
+				r.Header.Set("Comixifier-Name", "cutout")
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			},
+		},
+		{
+			name: "transform by malformed id",
+			prepare: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/v2/transforms/123", nil)
+			},
+			wantErr: "path parameter transformId: must be a uuid",
+		},
+		{
+			name: "undocumented path",
+			prepare: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/unknown", nil)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			r := test.prepare()
+			err := Validate(r)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Logf("error got: %s; expected: <nil>", err.Error())
+					t.FailNow()
+				}
+				return
+			}
+
+			if err == nil {
+				t.Logf("error is nil; expected: %s", test.wantErr)
+				t.FailNow()
+			}
+			if err.Error() != test.wantErr {
+				t.Logf("error got: %s; expected: %s", err.Error(), test.wantErr)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestValidate_KeepsBody_Unit(t *testing.T) {
+	body := `{"transformId": "0b8e3c2e-3f4c-11ed-b878-0242ac120002"}`
+	r := httptest.NewRequest(http.MethodPost, "/progress", strings.NewReader(body))
+
+	err := Validate(r)
+	if err != nil {
+		t.Logf("error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+
+	got, _ := io.ReadAll(r.Body)
+	if string(got) != body {
+		t.Logf("body got: %s; expected: %s", got, body)
+		t.FailNow()
+	}
+}
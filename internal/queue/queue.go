@@ -0,0 +1,131 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	pendingKey    = "transforms-queue"
+	processingKey = "transforms-processing"
+
+	// LeaseTTL is how long a popped transform belongs to its worker without renewal.
+	LeaseTTL = 30 * time.Second
+)
+
+var ErrEmpty = errors.New("queue is empty")
+
+// popScript moves a transform from the pending list to the processing one
+// and leases it to the worker in one step, so Recover never sees it unleased.
+var popScript = redis.NewScript(`
+local id = redis.call('RPOPLPUSH', KEYS[1], KEYS[2])
+if not id then
+	return false
+end
+redis.call('SET', id .. '-lease', ARGV[1], 'PX', ARGV[2])
+return id
+`)
+
+// recoverScript returns transforms with expired leases to the head of the pending list.
+var recoverScript = redis.NewScript(`
+local recovered = 0
+for _, id in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+	if redis.call('EXISTS', id .. '-lease') == 0 then
+		redis.call('LREM', KEYS[2], 0, id)
+		redis.call('RPUSH', KEYS[1], id)
+		recovered = recovered + 1
+	end
+end
+return recovered
+`)
+
+// Queue is a durable queue of transform ids kept in redis.
+// Popped ids stay in the processing list until they are acknowledged,
+// so transforms of a crashed process can be recovered.
+type Queue struct {
+	client *redis.Client
+	owner  string
+}
+
+func NewQueue(client *redis.Client, owner string) *Queue {
+	return &Queue{
+		client: client,
+		owner:  owner,
+	}
+}
+
+func (q *Queue) Push(ctx context.Context, transformId string) error {
+	err := q.client.LPush(ctx, pendingKey, transformId).Err()
+	if err != nil {
+		return fmt.Errorf("push to pending list: %w", err)
+	}
+	return nil
+}
+
+// Pop leases the oldest pending transform. It returns ErrEmpty if there is nothing to do.
+func (q *Queue) Pop(ctx context.Context) (string, error) {
+	id, err := popScript.Run(ctx, q.client, []string{pendingKey, processingKey},
+		q.owner, LeaseTTL.Milliseconds(),
+	).Text()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrEmpty
+	}
+	if err != nil {
+		return "", fmt.Errorf("pop from pending list: %w", err)
+	}
+	return id, nil
+}
+
+// Renew prolongs the lease of the popped transform.
+func (q *Queue) Renew(ctx context.Context, transformId string) error {
+	err := q.client.Set(ctx, leaseKey(transformId), q.owner, LeaseTTL).Err()
+	if err != nil {
+		return fmt.Errorf("renew lease: %w", err)
+	}
+	return nil
+}
+
+// Ack removes the processed transform from the queue.
+func (q *Queue) Ack(ctx context.Context, transformId string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, processingKey, 0, transformId)
+		pipe.Del(ctx, leaseKey(transformId))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("remove from processing list: %w", err)
+	}
+	return nil
+}
+
+// Requeue returns the popped transform to the head of the pending list, so the next free worker
+// of any process runs it.
+func (q *Queue) Requeue(ctx context.Context, transformId string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, processingKey, 0, transformId)
+		pipe.Del(ctx, leaseKey(transformId))
+		pipe.RPush(ctx, pendingKey, transformId)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("return to pending list: %w", err)
+	}
+	return nil
+}
+
+// Recover requeues transforms whose workers stopped renewing their leases.
+func (q *Queue) Recover(ctx context.Context) (int, error) {
+	n, err := recoverScript.Run(ctx, q.client, []string{pendingKey, processingKey}).Int()
+	if err != nil {
+		return 0, fmt.Errorf("requeue processing transforms: %w", err)
+	}
+	return n, nil
+}
+
+func leaseKey(transformId string) string {
+	return transformId + "-lease"
+}
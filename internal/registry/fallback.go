@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fallbacks are providers which take a transform over, in order, when the provider chosen for it fails.
+type Fallbacks map[string][]string
+
+// Chain returns the provider followed by its fallbacks.
+func (f Fallbacks) Chain(name string) []string {
+	return append([]string{name}, f[name]...)
+}
+
+// ParseFallbacks parses a list of chains like "VanceAI>cutout>face2comics,cutout>face2comics",
+// where the first provider of a chain falls back to the rest. Providers must be registered.
+func ParseFallbacks(raw string) (Fallbacks, error) {
+	fallbacks := make(Fallbacks)
+	for _, rawChain := range strings.Split(raw, ",") {
+		rawChain = strings.TrimSpace(rawChain)
+		if rawChain == "" {
+			continue
+		}
+
+		chain := strings.Split(rawChain, ">")
+		if len(chain) < 2 {
+			return nil, fmt.Errorf("chain %q: no fallbacks", rawChain)
+		}
+		seen := make(map[string]bool, len(chain))
+		for i, name := range chain {
+			name = strings.TrimSpace(name)
+			if _, ok := Get(name); !ok {
+				return nil, fmt.Errorf("chain %q: unknown provider %q", rawChain, name)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("chain %q: provider %s is listed twice", rawChain, name)
+			}
+			seen[name] = true
+			chain[i] = name
+		}
+
+		if _, ok := fallbacks[chain[0]]; ok {
+			return nil, fmt.Errorf("provider %s has two chains", chain[0])
+		}
+		fallbacks[chain[0]] = chain[1:]
+	}
+	return fallbacks, nil
+}
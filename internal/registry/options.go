@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"comixifier/internal"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Types of option values.
+const (
+	OptionTypeString  = "string"
+	OptionTypeInteger = "integer"
+)
+
+// OptionsError tells why options don't suit the provider.
+type OptionsError struct {
+	Option string
+	Reason string
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("option %s: %s", e.Option, e.Reason)
+}
+
+// ParseOptions validates options given by a client and returns them with defaults applied.
+func (p *Provider) ParseOptions(raw map[string]interface{}) (internal.Options, error) {
+	known := make(map[string]Option, len(p.Options))
+	for _, opt := range p.Options {
+		known[opt.Name] = opt
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := known[name]; !ok {
+			return nil, &OptionsError{Option: name, Reason: fmt.Sprintf("is not supported by %s", p.Name)}
+		}
+	}
+
+	options := make(internal.Options, len(p.Options))
+	for _, opt := range p.Options {
+		value, ok := raw[opt.Name]
+		if !ok || value == nil {
+			if opt.Default != "" {
+				options[opt.Name] = opt.Default
+			}
+			continue
+		}
+
+		str, err := opt.parse(value)
+		if err != nil {
+			return nil, &OptionsError{Option: opt.Name, Reason: err.Error()}
+		}
+		options[opt.Name] = str
+	}
+
+	return options, nil
+}
+
+func (o *Option) parse(value interface{}) (string, error) {
+	var str string
+	switch o.Type {
+	case OptionTypeInteger:
+		var n int64
+		switch v := value.(type) {
+		case float64:
+			n = int64(v)
+			if float64(n) != v {
+				return "", fmt.Errorf("must be an integer")
+			}
+		case json.Number:
+			var err error
+			n, err = v.Int64()
+			if err != nil {
+				return "", fmt.Errorf("must be an integer")
+			}
+		case string:
+			var err error
+			n, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("must be an integer")
+			}
+		default:
+			return "", fmt.Errorf("must be an integer")
+		}
+		if o.Minimum != nil && n < *o.Minimum {
+			return "", fmt.Errorf("must be at least %d", *o.Minimum)
+		}
+		if o.Maximum != nil && n > *o.Maximum {
+			return "", fmt.Errorf("must be at most %d", *o.Maximum)
+		}
+		str = strconv.FormatInt(n, 10)
+	default:
+		v, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("must be a string")
+		}
+		if v == "" {
+			return "", fmt.Errorf("must not be empty")
+		}
+		str = v
+	}
+
+	if len(o.Values) == 0 {
+		return str, nil
+	}
+	for _, allowed := range o.Values {
+		if str == allowed {
+			return str, nil
+		}
+	}
+	return "", fmt.Errorf("must be one of %v", o.Values)
+}
+
+// Int returns a pointer to n, it is handy for option limits.
+func Int(n int64) *int64 {
+	return &n
+}
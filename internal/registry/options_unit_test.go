@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"comixifier/internal"
+	"reflect"
+	"testing"
+)
+
+func TestProvider_ParseOptions_Unit(t *testing.T) {
+	p := &Provider{
+		Name: "test",
+		Options: []Option{
+			{Name: "style", Type: OptionTypeInteger, Default: "5", Minimum: Int(1), Maximum: Int(9)},
+			{Name: "model", Type: OptionTypeString, Values: []string{"a", "b"}},
+		},
+	}
+
+	type testCase struct {
+		name    string
+		raw     map[string]interface{}
+		want    internal.Options
+		wantErr string
+	}
+	tests := []testCase{
+		{
+			name: "defaults",
+			raw:  nil,
+			want: internal.Options{"style": "5"},
+		},
+		{
+			name: "correct",
+			raw:  map[string]interface{}{"style": float64(3), "model": "b"},
+			want: internal.Options{"style": "3", "model": "b"},
+		},
+		{
+			name:    "unknown option",
+			raw:     map[string]interface{}{"size": "10"},
+			wantErr: "option size: is not supported by test",
+		},
+		{
+			name:    "not integer",
+			raw:     map[string]interface{}{"style": 2.5},
+			wantErr: "option style: must be an integer",
+		},
+		{
+			name:    "out of range",
+			raw:     map[string]interface{}{"style": float64(10)},
+			wantErr: "option style: must be at most 9",
+		},
+		{
+			name:    "not allowed value",
+			raw:     map[string]interface{}{"model": "c"},
+			wantErr: "option model: must be one of [a b]",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := p.ParseOptions(test.raw)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Logf("error got: %v; expected: %s", err, test.wantErr)
+					t.FailNow()
+				}
+				return
+			}
+
+			if err != nil {
+				t.Logf("error got: %s; expected: <nil>", err.Error())
+				t.FailNow()
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Logf("options got: %v; expected: %v", got, test.want)
+				t.FailNow()
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"comixifier/internal"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	mu        sync.RWMutex
+	providers = make(map[string]*Provider)
+)
+
+// Provider describes a comixifier which can be chosen by clients.
+type Provider struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	InputFormats []string `json:"inputFormats"`
+	MaxInputSize int64    `json:"maxInputSize"`
+	// MaxResolution is the longest side of images sent to the provider, larger inputs are downscaled.
+	MaxResolution int `json:"maxResolution,omitempty"`
+	// Credits is an estimated cost of one transform in credits of the provider account.
+	Credits float64  `json:"credits,omitempty"`
+	Options []Option `json:"options"`
+
+	New func() internal.Comixifier `json:"-"`
+}
+
+// Option describes a per-request setting supported by a provider.
+type Option struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Default     string   `json:"default,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Minimum     *int64   `json:"minimum,omitempty"`
+	Maximum     *int64   `json:"maximum,omitempty"`
+}
+
+// Accepts reports whether the provider takes images of the MIME type.
+func (p *Provider) Accepts(contentType string) bool {
+	for _, format := range p.InputFormats {
+		if format == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Register makes a provider available by its name.
+// It panics if a provider with the same name is already registered.
+func Register(p *Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if p == nil || p.New == nil {
+		panic("registry: register nil provider")
+	}
+	if _, ok := providers[p.Name]; ok {
+		panic(fmt.Sprintf("registry: provider %s registered twice", p.Name))
+	}
+	if p.Options == nil {
+		p.Options = make([]Option, 0)
+	}
+	providers[p.Name] = p
+}
+
+// Unregister makes the provider unavailable, for providers disabled by the config.
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(providers, name)
+}
+
+// Get returns a provider by its name.
+func Get(name string) (*Provider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	p, ok := providers[name]
+	return p, ok
+}
+
+// List returns all registered providers sorted by name.
+func List() []*Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]*Provider, 0, len(providers))
+	for _, p := range providers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"comixifier/internal"
+	"testing"
+)
+
+func TestRegistry_Unit(t *testing.T) {
+	newComixifier := func() internal.Comixifier { return nil }
+
+	Register(&Provider{Name: "b-test", New: newComixifier})
+	Register(&Provider{Name: "a-test", New: newComixifier})
+
+	p, ok := Get("a-test")
+	if !ok {
+		t.Logf("provider a-test not found")
+		t.FailNow()
+	}
+	if p.Options == nil {
+		t.Logf("options of a-test is nil; expected: empty list")
+		t.FailNow()
+	}
+
+	if _, ok := Get("unknown"); ok {
+		t.Logf("provider unknown found; expected: not found")
+		t.FailNow()
+	}
+
+	list := List()
+	if len(list) != 2 || list[0].Name != "a-test" || list[1].Name != "b-test" {
+		t.Logf("list got: %v; expected: [a-test b-test]", list)
+		t.FailNow()
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Logf("register duplicate: no panic")
+			t.FailNow()
+		}
+	}()
+	Register(&Provider{Name: "a-test", New: newComixifier})
+}
+
+// Providers are registered by TestRegistry_Unit.
+func TestParseFallbacks_Unit(t *testing.T) {
+	fallbacks, err := ParseFallbacks(" a-test > b-test ,")
+	if err != nil {
+		t.Logf("error got: %s; expected: <nil>", err.Error())
+		t.FailNow()
+	}
+	chain := fallbacks.Chain("a-test")
+	if len(chain) != 2 || chain[0] != "a-test" || chain[1] != "b-test" {
+		t.Logf("chain of a-test got: %v; expected: [a-test b-test]", chain)
+		t.FailNow()
+	}
+	chain = fallbacks.Chain("b-test")
+	if len(chain) != 1 || chain[0] != "b-test" {
+		t.Logf("chain of b-test got: %v; expected: [b-test]", chain)
+		t.FailNow()
+	}
+
+	for _, raw := range []string{"a-test", "a-test>unknown", "a-test>b-test>a-test", "a-test>b-test,a-test>b-test"} {
+		_, err = ParseFallbacks(raw)
+		if err == nil {
+			t.Logf("%q: error got: <nil>; expected error", raw)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"comixifier/internal/auth"
+	"comixifier/internal/state"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const adminKeysPath = "/admin/keys"
+
+// publicPaths are served without an API key.
+var publicPaths = map[string]bool{
+	"/openapi.json":   true,
+	"/comixifiers":    true,
+	"/v2/comixifiers": true,
+}
+
+// authenticate rejects requests without a valid API key and passes the key of the rest in their context.
+// Admin endpoints are authenticated by the admin token instead.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.keys == nil || publicPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Usage of all keys is reported to admins.
+		if r.URL.Path == usagePath && s.isAdmin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := readSecret(r)
+		if secret == "" {
+			writeUnauthorized(w, "api key is required")
+			return
+		}
+		key, err := s.keys.Authenticate(r.Context(), secret)
+		if errors.Is(err, auth.ErrInvalidKey) {
+			writeUnauthorized(w, "invalid api key")
+			return
+		}
+		if err != nil {
+			log.Printf("auth: authenticate api key: %s\n", err.Error())
+			writeError(w, errInternal())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithKey(r.Context(), key)))
+	})
+}
+
+// readSecret reads the API key from the Authorization bearer token or the X-API-Key header.
+func readSecret(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="comixifier"`)
+	writeError(w, newApiError(http.StatusUnauthorized, CodeUnauthorized, message))
+}
+
+// requestKeyId is the id of the key the request was authenticated with, empty if authentication is disabled.
+func requestKeyId(ctx context.Context) string {
+	key := auth.FromContext(ctx)
+	if key == nil {
+		return ""
+	}
+	return key.KeyId
+}
+
+// ownedBy reports whether the request may use a transform or a group created with the key.
+func ownedBy(ctx context.Context, keyId string) bool {
+	key := auth.FromContext(ctx)
+	return key == nil || key.KeyId == keyId
+}
+
+// authorizeTransform hides transforms of other keys as if they didn't exist.
+func (s *Server) authorizeTransform(ctx context.Context, transformId string) *apiError {
+	if auth.FromContext(ctx) == nil {
+		return nil
+	}
+
+	job, err := s.transforms.Job(ctx, transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		return errTransformNotFound()
+	}
+	if err != nil {
+		log.Printf("auth: get job: %s\n", err.Error())
+		return errInternal()
+	}
+	if !ownedBy(ctx, job.KeyId) {
+		return errTransformNotFound()
+	}
+	return nil
+}
+
+// handleAdminKeys serves GET and POST /admin/keys, which list and create API keys.
+func (s *Server) handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !s.authorizeAdmin(w, r) || !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		keys, err := s.keys.List(r.Context())
+		if err != nil {
+			log.Printf("auth: list api keys: %s\n", err.Error())
+			writeError(w, errInternal())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": keys,
+		})
+		return
+	}
+
+	rawReqBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "read request body: "+err.Error()))
+		return
+	}
+	reqBody := struct {
+		Name      string `json:"name"`
+		Retention int64  `json:"retention"`
+	}{}
+	err = json.Unmarshal(rawReqBody, &reqBody)
+	if err != nil {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "request body is not valid json: "+err.Error()))
+		return
+	}
+	if strings.TrimSpace(reqBody.Name) == "" {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "name is required"))
+		return
+	}
+	var retention time.Duration
+	if reqBody.Retention != 0 {
+		var apiErr *apiError
+		retention, apiErr = checkRetention(reqBody.Retention)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+	}
+
+	key, secret, err := s.keys.Create(r.Context(), strings.TrimSpace(reqBody.Name), retention)
+	if err != nil {
+		log.Printf("auth: create api key: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	w.Header().Set("Location", adminKeysPath+"/"+key.KeyId)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"keyId":     key.KeyId,
+		"name":      key.Name,
+		"prefix":    key.Prefix,
+		"createdAt": key.CreatedAt,
+		"retention": key.Retention,
+		"secret":    secret,
+	})
+}
+
+// handleAdminKey serves PATCH /admin/keys/{id}, which changes the retention of the API key,
+// and DELETE /admin/keys/{id}, which revokes it.
+func (s *Server) handleAdminKey(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !s.authorizeAdmin(w, r) || !allowMethods(w, r, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+
+	keyId := strings.Trim(strings.TrimPrefix(r.URL.Path, adminKeysPath+"/"), "/")
+	if r.Method == http.MethodPatch {
+		s.updateKey(w, r, keyId)
+		return
+	}
+
+	err := s.keys.Revoke(r.Context(), keyId)
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		writeError(w, newApiError(http.StatusNotFound, CodeApiKeyNotFound, "api key not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("auth: revoke api key: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateKey sets the retention of the key, zero returns it to the default retention.
+func (s *Server) updateKey(w http.ResponseWriter, r *http.Request, keyId string) {
+	rawReqBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "read request body: "+err.Error()))
+		return
+	}
+	reqBody := struct {
+		Retention *int64 `json:"retention"`
+	}{}
+	err = json.Unmarshal(rawReqBody, &reqBody)
+	if err != nil {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "request body is not valid json: "+err.Error()))
+		return
+	}
+	if reqBody.Retention == nil {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "retention is required"))
+		return
+	}
+	var retention time.Duration
+	if *reqBody.Retention != 0 {
+		var apiErr *apiError
+		retention, apiErr = checkRetention(*reqBody.Retention)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+	}
+
+	key, err := s.keys.SetRetention(r.Context(), keyId, retention)
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		writeError(w, newApiError(http.StatusNotFound, CodeApiKeyNotFound, "api key not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("auth: set retention of api key: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// authorizeAdmin responds with 401 unless the request has the admin token.
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !s.isAdmin(r) {
+		writeUnauthorized(w, "admin token is required")
+		return false
+	}
+	return true
+}
+
+// isAdmin reports whether the request has the admin token.
+func (s *Server) isAdmin(r *http.Request) bool {
+	secret := readSecret(r)
+	return s.adminToken != "" && secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s.adminToken)) == 1
+}
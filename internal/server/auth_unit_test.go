@@ -0,0 +1,109 @@
+package server
+
+import (
+	"comixifier/internal/auth"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Authenticate_Unit(t *testing.T) {
+	handler := NewServer(nil, nil, nil, nil, nil, "test", nil, false, auth.NewKeys(nil), nil, nil, nil, "admin-token").Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		header     string
+		value      string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "no key", method: http.MethodPost, target: "/v2/transforms", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "foreign bearer token", method: http.MethodGet, target: "/v2/transforms/some_id", header: "Authorization", value: "Bearer token", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "foreign api key", method: http.MethodPost, target: "/progress", header: "X-API-Key", value: "key", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "public document", method: http.MethodGet, target: "/openapi.json", wantStatus: http.StatusOK},
+		{name: "public comixifiers", method: http.MethodGet, target: "/v2/comixifiers", wantStatus: http.StatusOK},
+		{name: "usage without key", method: http.MethodGet, target: "/usage", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "usage of admin", method: http.MethodGet, target: "/usage", header: "Authorization", value: "Bearer admin-token", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "admin without token", method: http.MethodGet, target: "/admin/keys", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "admin with api key", method: http.MethodDelete, target: "/admin/keys/6ba7b810-9dad-11d1-80b4-00c04fd430c8", header: "X-API-Key", value: "cmx_key", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "admin wrong method", method: http.MethodPut, target: "/admin/keys", header: "Authorization", value: "Bearer admin-token", wantStatus: http.StatusMethodNotAllowed, wantCode: CodeMethodNotAllowed},
+		{name: "admin without name", method: http.MethodPost, target: "/admin/keys", header: "Authorization", value: "bearer admin-token", body: `{"name": " "}`, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidRequest},
+		{name: "admin short retention", method: http.MethodPost, target: "/admin/keys", header: "Authorization", value: "Bearer admin-token", body: `{"name": "client", "retention": 5}`, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidRequest},
+		{name: "admin update without retention", method: http.MethodPatch, target: "/admin/keys/6ba7b810-9dad-11d1-80b4-00c04fd430c8", header: "Authorization", value: "Bearer admin-token", body: `{}`, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.wantStatus {
+			t.Logf("%s: status got: %d; expected: %d; body: %s", test.name, w.Code, test.wantStatus, w.Body.String())
+			t.FailNow()
+		}
+		if test.wantCode == "" {
+			continue
+		}
+		body := struct {
+			Error *apiError `json:"error"`
+		}{}
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if err != nil || body.Error == nil || body.Error.Code != test.wantCode {
+			t.Logf("%s: error got: %s; expected code: %s", test.name, w.Body.String(), test.wantCode)
+			t.FailNow()
+		}
+		if test.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Logf("%s: no WWW-Authenticate header", test.name)
+			t.FailNow()
+		}
+	}
+
+	noAdmin := NewServer(nil, nil, nil, nil, nil, "test", nil, false, auth.NewKeys(nil), nil, nil, nil, "").Handler()
+	w := httptest.NewRecorder()
+	noAdmin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/keys", nil))
+	if w.Code != http.StatusNotFound {
+		t.Logf("admin without admin token configured status got: %d; expected: %d", w.Code, http.StatusNotFound)
+		t.FailNow()
+	}
+}
+
+func TestOwnedBy_Unit(t *testing.T) {
+	if !ownedBy(context.Background(), "key") {
+		t.Logf("transform not owned with authentication disabled")
+		t.FailNow()
+	}
+
+	ctx := auth.WithKey(context.Background(), &auth.Key{KeyId: "key"})
+	if !ownedBy(ctx, "key") || ownedBy(ctx, "other") || ownedBy(ctx, "") {
+		t.Logf("ownership of key transforms is wrong")
+		t.FailNow()
+	}
+	if requestKeyId(ctx) != "key" || requestKeyId(context.Background()) != "" {
+		t.Logf("request key ids got: %q, %q", requestKeyId(ctx), requestKeyId(context.Background()))
+		t.FailNow()
+	}
+}
+
+func TestServer_ResultCacheControl_Unit(t *testing.T) {
+	for _, test := range []struct {
+		keys     *auth.Keys
+		expected string
+	}{
+		{keys: nil, expected: "public, max-age=600"},
+		{keys: auth.NewKeys(nil), expected: "private, max-age=600"},
+	} {
+		s := NewServer(nil, nil, nil, nil, nil, "test", nil, false, test.keys, nil, nil, nil, "")
+		if got := s.resultCacheControl(); got != test.expected {
+			t.Logf("cache control with keys %t got: %q; expected: %q", test.keys != nil, got, test.expected)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package server
+
+import (
+	"archive/zip"
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	v2BatchesPath = "/v2/batches/"
+	// maxBatchItems is how many images a batch can have.
+	maxBatchItems = 500
+	// maxBatchArchiveSize is the largest ZIP archive of a batch.
+	maxBatchArchiveSize = 1 << 30
+	// maxBatchBodySize is the largest request body of a batch, the archive with the rest of multipart bodies.
+	maxBatchBodySize = maxBatchArchiveSize + 1<<20
+	// failuresManifestName is the entry of the batch result listing images which weren't transformed.
+	failuresManifestName = "failures.json"
+)
+
+// batchRequest is a new batch asked by a client. Images come either from the archive or from the image storage.
+type batchRequest struct {
+	comixifier string
+	options    map[string]interface{}
+	archive    *zip.Reader
+	inputs     []*objectRef
+	noCache    bool
+	retention  time.Duration
+	// limitHeaders receive rate limit headers of the last item.
+	limitHeaders http.Header
+}
+
+// batchItemState is a batch item along with the state of its transform.
+type batchItemState struct {
+	Name        string       `json:"name"`
+	TransformId string       `json:"transformId,omitempty"`
+	Status      state.Status `json:"status"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// handleV2Batches serves POST /v2/batches. The body is a ZIP archive of images, a multipart form
+// with archive, options and comixifier parts, or a JSON object listing image storage keys.
+func (s *Server) handleV2Batches(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	noCache, apiErr := readNoCache(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	retention, apiErr := readRetention(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	req := &batchRequest{
+		comixifier:   firstNonEmpty(r.URL.Query().Get("comixifier"), r.Header.Get("Comixifier-Name")),
+		noCache:      noCache,
+		retention:    retention,
+		limitHeaders: w.Header(),
+	}
+	cleanup, apiErr := readBatchRequest(w, r, req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	defer cleanup()
+
+	batch, apiErr := s.createBatch(r.Context(), req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	items, apiErr := s.batchItems(r.Context(), batch)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Location", v2BatchesPath+batch.BatchId)
+	writeJSON(w, http.StatusAccepted, batchResource(batch, items))
+}
+
+// handleV2Batch serves /v2/batches/{id} and its result.
+func (s *Server) handleV2Batch(w http.ResponseWriter, r *http.Request) {
+	batchId, subresource := splitTransformPath(strings.TrimPrefix(r.URL.Path, v2BatchesPath))
+	if batchId == "" || (subresource != "" && subresource != "result") {
+		writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "not found"))
+		return
+	}
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	batch, err := s.transforms.Batch(r.Context(), batchId)
+	if err == nil && !ownedBy(r.Context(), batch.KeyId) {
+		err = state.ErrBatchNotFound
+	}
+	if errors.Is(err, state.ErrBatchNotFound) {
+		writeError(w, newApiError(http.StatusNotFound, CodeBatchNotFound, "batch not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("batch: get batch from state storage: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	items, apiErr := s.batchItems(r.Context(), batch)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	if subresource == "" {
+		writeJSON(w, http.StatusOK, batchResource(batch, items))
+		return
+	}
+	s.writeBatchResult(w, r, batch, items)
+}
+
+// batchBody is the request body of a batch cut off at maxBatchBodySize.
+type batchBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func newBatchBody(w http.ResponseWriter, body io.ReadCloser) *batchBody {
+	return &batchBody{ReadCloser: http.MaxBytesReader(w, body, maxBatchBodySize)}
+}
+
+func (b *batchBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// cutOff reports whether reading failed since the body is too large.
+func (b *batchBody) cutOff() bool {
+	return b.n >= maxBatchBodySize
+}
+
+// readBatchRequest fills req with images and options of the request.
+// The returned cleanup removes temp files of the request body.
+func readBatchRequest(w http.ResponseWriter, r *http.Request, req *batchRequest) (func(), *apiError) {
+	noCleanup := func() {}
+	body := newBatchBody(w, r.Body)
+	r.Body = body
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		reqBody := struct {
+			Comixifier string                 `json:"comixifier"`
+			Options    map[string]interface{} `json:"options"`
+			Inputs     []*objectRef           `json:"inputs"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&reqBody)
+		if err != nil && body.cutOff() {
+			return noCleanup, errBatchTooLarge()
+		}
+		if err != nil {
+			return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "request body is not valid json: "+err.Error())
+		}
+		if reqBody.Comixifier != "" {
+			req.comixifier = reqBody.Comixifier
+		}
+		req.options = reqBody.Options
+		req.inputs = reqBody.Inputs
+		return noCleanup, nil
+	case "multipart/form-data":
+		err := r.ParseMultipartForm(maxMultipartMemory)
+		if err != nil && body.cutOff() {
+			return noCleanup, errBatchTooLarge()
+		}
+		if err != nil {
+			return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "parse multipart body: "+err.Error())
+		}
+		cleanup := func() {
+			r.MultipartForm.RemoveAll()
+		}
+
+		if comixifier := r.FormValue("comixifier"); comixifier != "" {
+			req.comixifier = comixifier
+		}
+		rawOptions, err := readFormPart(r, "options")
+		if err != nil {
+			cleanup()
+			return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "read options part: "+err.Error())
+		}
+		if len(rawOptions) > 0 {
+			err = json.Unmarshal(rawOptions, &req.options)
+			if err != nil {
+				cleanup()
+				return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidOptions,
+					"options part must be a JSON object: "+err.Error(),
+				)
+			}
+		}
+
+		archive, archiveHeader, err := r.FormFile("archive")
+		if err != nil {
+			cleanup()
+			return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "archive part is required")
+		}
+		req.archive, err = zip.NewReader(archive, archiveHeader.Size)
+		if err != nil {
+			archive.Close()
+			cleanup()
+			return noCleanup, errInvalidArchive(err)
+		}
+		return func() {
+			archive.Close()
+			r.MultipartForm.RemoveAll()
+		}, nil
+	default:
+		return readArchiveBody(r, req)
+	}
+}
+
+// readArchiveBody saves the ZIP archive of the request body to a temp file, since it's read out of order.
+func readArchiveBody(r *http.Request, req *batchRequest) (func(), *apiError) {
+	noCleanup := func() {}
+
+	archiveFile, err := os.CreateTemp("", "batch_*.zip")
+	if err != nil {
+		log.Printf("batch: create temp archive file: %s\n", err.Error())
+		return noCleanup, errInternal()
+	}
+	cleanup := func() {
+		archiveFile.Close()
+		os.Remove(archiveFile.Name())
+	}
+
+	size, err := io.Copy(archiveFile, io.LimitReader(r.Body, maxBatchArchiveSize+1))
+	if err != nil {
+		cleanup()
+		return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "read request body: "+err.Error())
+	}
+	if size > maxBatchArchiveSize {
+		cleanup()
+		return noCleanup, errBatchTooLarge()
+	}
+
+	req.archive, err = zip.NewReader(archiveFile, size)
+	if err != nil {
+		cleanup()
+		return noCleanup, errInvalidArchive(err)
+	}
+	return cleanup, nil
+}
+
+// createBatch creates a transform of every image of the batch. Images which are rejected
+// don't fail the batch, they are recorded as failed items.
+func (s *Server) createBatch(ctx context.Context, req *batchRequest) (*state.Batch, *apiError) {
+	provider, ok := registry.Get(req.comixifier)
+	if !ok {
+		return nil, newApiError(http.StatusBadRequest, CodeUnknownComixifier,
+			fmt.Sprintf("unknown comixifier: %q", req.comixifier),
+		)
+	}
+	_, err := provider.ParseOptions(req.options)
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidOptions, err.Error())
+	}
+
+	var files []*zip.File
+	if req.archive != nil {
+		for _, f := range req.archive.File {
+			if isBatchImage(f) {
+				files = append(files, f)
+			}
+		}
+	}
+	total := len(files) + len(req.inputs)
+	if total == 0 {
+		return nil, newApiError(http.StatusBadRequest, CodeEmptyInput, "batch has no images")
+	}
+	if total > maxBatchItems {
+		return nil, newApiError(http.StatusRequestEntityTooLarge, CodeBatchTooLarge,
+			fmt.Sprintf("batch has %d images, at most %d are allowed", total, maxBatchItems),
+		)
+	}
+
+	batchId, err := uuid.NewUUID()
+	if err != nil {
+		log.Printf("batch: generate uuid: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	batch := &state.Batch{
+		BatchId:    batchId.String(),
+		Comixifier: provider.Name,
+		Items:      make([]*state.BatchItem, 0, total),
+		CreatedAt:  time.Now().UTC(),
+		KeyId:      requestKeyId(ctx),
+	}
+
+	for _, f := range files {
+		batch.Items = append(batch.Items, s.createArchiveItem(ctx, batch.BatchId, req, f))
+	}
+	for _, input := range req.inputs {
+		item := &state.BatchItem{}
+		if input == nil || input.Key == "" {
+			item.Error = newApiError(http.StatusBadRequest, CodeInvalidRequest, "input key is required").Error()
+			batch.Items = append(batch.Items, item)
+			continue
+		}
+
+		item.Name = input.Key
+		job, apiErr := s.createTransform(ctx, &transformRequest{
+			comixifier:   req.comixifier,
+			options:      req.options,
+			input:        input,
+			groupId:      batch.BatchId,
+			noCache:      req.noCache,
+			retention:    req.retention,
+			limitHeaders: req.limitHeaders,
+		})
+		if apiErr != nil {
+			item.Error = apiErr.Error()
+		} else {
+			item.TransformId = job.TransformId
+		}
+		batch.Items = append(batch.Items, item)
+	}
+
+	err = s.transforms.CreateBatch(ctx, batch)
+	if err != nil {
+		log.Printf("batch: create batch in state storage: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	return batch, nil
+}
+
+func (s *Server) createArchiveItem(ctx context.Context, batchId string, req *batchRequest, f *zip.File) *state.BatchItem {
+	item := &state.BatchItem{Name: f.Name}
+
+	image, err := f.Open()
+	if err != nil {
+		item.Error = errInvalidArchive(err).Error()
+		return item
+	}
+	defer image.Close()
+
+	job, apiErr := s.createTransform(ctx, &transformRequest{
+		comixifier:   req.comixifier,
+		options:      req.options,
+		image:        image,
+		imageSize:    int64(f.UncompressedSize64),
+		groupId:      batchId,
+		noCache:      req.noCache,
+		retention:    req.retention,
+		limitHeaders: req.limitHeaders,
+	})
+	if apiErr != nil {
+		item.Error = apiErr.Error()
+		return item
+	}
+
+	item.TransformId = job.TransformId
+	return item
+}
+
+// isBatchImage skips directories and metadata archivers put next to images.
+func isBatchImage(f *zip.File) bool {
+	if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+		return false
+	}
+	return !strings.HasPrefix(path.Base(f.Name), ".")
+}
+
+// batchItems returns the current state of every item of the batch.
+func (s *Server) batchItems(ctx context.Context, batch *state.Batch) ([]*batchItemState, *apiError) {
+	items := make([]*batchItemState, 0, len(batch.Items))
+	for _, item := range batch.Items {
+		status, itemErr, apiErr := s.itemState(ctx, item.TransformId, item.Error)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		items = append(items, &batchItemState{
+			Name:        item.Name,
+			TransformId: item.TransformId,
+			Status:      status,
+			Error:       itemErr,
+		})
+	}
+	return items, nil
+}
+
+// itemState returns the status and the error of a transform which is an item of a batch or a comparison.
+// Items rejected before a transform was created and expired transforms are failed.
+func (s *Server) itemState(ctx context.Context, transformId string, rejectErr string) (state.Status, string, *apiError) {
+	if transformId == "" {
+		return state.StatusFatal, rejectErr, nil
+	}
+
+	event, err := s.transforms.Snapshot(ctx, transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		return state.StatusFatal, "transform expired", nil
+	}
+	if err != nil {
+		log.Printf("batch: get transform state: %s\n", err.Error())
+		return "", "", errInternal()
+	}
+	return event.Status, event.Error, nil
+}
+
+// batchResource is a representation of the batch in the v2 API.
+func batchResource(batch *state.Batch, items []*batchItemState) map[string]interface{} {
+	done, failed, pending := 0, 0, 0
+	for _, item := range items {
+		switch {
+		case item.Status == state.StatusFinish:
+			done++
+		case item.Status.IsFinal():
+			failed++
+		default:
+			pending++
+		}
+	}
+
+	links := map[string]string{
+		"self": v2BatchesPath + batch.BatchId,
+	}
+	if pending == 0 {
+		links["result"] = v2BatchesPath + batch.BatchId + "/result"
+	}
+
+	return map[string]interface{}{
+		"batchId":    batch.BatchId,
+		"comixifier": batch.Comixifier,
+		"createdAt":  batch.CreatedAt,
+		"total":      len(items),
+		"done":       done,
+		"failed":     failed,
+		"pending":    pending,
+		"items":      items,
+		"links":      links,
+	}
+}
+
+// writeBatchResult streams a ZIP archive of results of the finished batch items
+// with a manifest of the items which failed.
+func (s *Server) writeBatchResult(w http.ResponseWriter, r *http.Request, batch *state.Batch, items []*batchItemState) {
+	for _, item := range items {
+		if !item.Status.IsFinal() {
+			writeError(w, newApiError(http.StatusConflict, CodeBatchNotFinished, "batch has pending transforms"))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="batch_%s.zip"`, batch.BatchId))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	names := make(map[string]bool)
+	failures := make([]*batchItemState, 0)
+	for _, item := range items {
+		if item.Status != state.StatusFinish {
+			failures = append(failures, item)
+			continue
+		}
+
+		err := s.writeBatchItem(r.Context(), archive, names, item)
+		if err != nil {
+			log.Printf("batch: add result of %s to archive: %s\n", item.TransformId, err.Error())
+			failures = append(failures, &batchItemState{
+				Name:        item.Name,
+				TransformId: item.TransformId,
+				Status:      item.Status,
+				Error:       "result is not available",
+			})
+		}
+	}
+
+	manifest, err := archive.Create(failuresManifestName)
+	if err != nil {
+		log.Printf("batch: add failures manifest to archive: %s\n", err.Error())
+		return
+	}
+	encoder := json.NewEncoder(manifest)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(failures)
+	if err != nil {
+		log.Printf("batch: write failures manifest: %s\n", err.Error())
+		return
+	}
+
+	err = archive.Close()
+	if err != nil {
+		log.Printf("batch: finish archive: %s\n", err.Error())
+	}
+}
+
+// writeBatchItem adds the result of the item to the archive. The result is read whole before its entry
+// is created, so the archive gets no truncated entry of an item which fails.
+func (s *Server) writeBatchItem(ctx context.Context, archive *zip.Writer, names map[string]bool, item *batchItemState) error {
+	key, err := s.transforms.File(ctx, item.TransformId)
+	if err != nil {
+		return fmt.Errorf("get result key: %w", err)
+	}
+
+	resultInfo, err := s.images.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("stat result: %w", err)
+	}
+
+	result, err := s.images.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("get result: %w", err)
+	}
+	defer result.Close()
+
+	resultFile, err := os.CreateTemp("", "batch_item_*")
+	if err != nil {
+		return fmt.Errorf("create temp result file: %w", err)
+	}
+	defer os.Remove(resultFile.Name())
+	defer resultFile.Close()
+
+	n, err := io.Copy(resultFile, result)
+	if err != nil {
+		return fmt.Errorf("copy result to temp result file: %w", err)
+	}
+	if n != resultInfo.Size {
+		return fmt.Errorf("copy result: got %d of %d bytes", n, resultInfo.Size)
+	}
+	_, err = resultFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("rewind temp result file: %w", err)
+	}
+
+	// Images are compressed already.
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     batchEntryName(names, item.Name, path.Ext(key)),
+		Method:   zip.Store,
+		Modified: resultInfo.LastModified,
+	})
+	if err != nil {
+		return fmt.Errorf("create entry: %w", err)
+	}
+
+	_, err = io.Copy(entry, resultFile)
+	if err != nil {
+		return fmt.Errorf("copy result: %w", err)
+	}
+	return nil
+}
+
+// batchEntryName names the result after the source image, keeping names in the archive unique.
+func batchEntryName(names map[string]bool, source string, ext string) string {
+	base := strings.TrimPrefix(path.Clean("/"+source), "/")
+	base = strings.TrimSuffix(base, path.Ext(base))
+	if base == "" {
+		base = "result"
+	}
+
+	name := base + ext
+	for i := 2; names[name] || name == failuresManifestName; i++ {
+		name = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+	names[name] = true
+	return name
+}
+
+func errInvalidArchive(err error) *apiError {
+	return newApiError(http.StatusBadRequest, CodeInvalidArchive, "invalid zip archive: "+err.Error())
+}
+
+func errBatchTooLarge() *apiError {
+	return newApiError(http.StatusRequestEntityTooLarge, CodeBatchTooLarge,
+		fmt.Sprintf("archive is larger than %d bytes", maxBatchArchiveSize),
+	)
+}
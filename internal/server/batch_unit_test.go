@@ -0,0 +1,67 @@
+package server
+
+import (
+	"archive/zip"
+	"comixifier/internal/state"
+	"testing"
+)
+
+func TestBatchEntryName_Unit(t *testing.T) {
+	names := make(map[string]bool)
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "party/IMG_1.jpeg", want: "party/IMG_1.png"},
+		{source: "party/IMG_1.heic", want: "party/IMG_1_2.png"},
+		{source: "../../etc/passwd", want: "etc/passwd.png"},
+		{source: "failures.json", want: "failures.png"},
+	}
+	for _, test := range tests {
+		got := batchEntryName(names, test.source, ".png")
+		if got != test.want {
+			t.Logf("%s: name got: %s; expected: %s", test.source, got, test.want)
+			t.FailNow()
+		}
+	}
+}
+
+func TestIsBatchImage_Unit(t *testing.T) {
+	tests := map[string]bool{
+		"photo.jpeg":             true,
+		"party/photo.png":        true,
+		"party/":                 false,
+		"__MACOSX/party/._photo": false,
+		"party/.DS_Store":        false,
+	}
+	for name, want := range tests {
+		f := &zip.File{FileHeader: zip.FileHeader{Name: name}}
+		if got := isBatchImage(f); got != want {
+			t.Logf("%s: image got: %t; expected: %t", name, got, want)
+			t.FailNow()
+		}
+	}
+}
+
+func TestBatchResource_Unit(t *testing.T) {
+	batch := &state.Batch{BatchId: "batch_id", Comixifier: "cutout"}
+	items := []*batchItemState{
+		{Name: "a.png", Status: state.StatusFinish},
+		{Name: "b.png", Status: state.StatusFatal},
+		{Name: "c.png", Status: state.StatusWait},
+		{Name: "d.png", Status: state.StatusFinish},
+	}
+
+	resource := batchResource(batch, items)
+	if resource["done"] != 2 || resource["failed"] != 1 || resource["pending"] != 1 {
+		t.Logf("progress got: done %v, failed %v, pending %v; expected: 2, 1, 1",
+			resource["done"], resource["failed"], resource["pending"],
+		)
+		t.FailNow()
+	}
+	if _, ok := resource["links"].(map[string]string)["result"]; ok {
+		t.Logf("result link of the pending batch got: present; expected: absent")
+		t.FailNow()
+	}
+}
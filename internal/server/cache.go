@@ -0,0 +1,102 @@
+package server
+
+import (
+	"comixifier/internal/cache"
+	"comixifier/internal/state"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// handleV2Cache serves GET /v2/cache with lookups of cached results.
+func (s *Server) handleV2Cache(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	stats, err := s.cache.Stats(r.Context())
+	if err != nil {
+		log.Printf("cache: get stats: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":     s.cache.Enabled(),
+		"ttl":         int(s.cache.TTL().Seconds()),
+		"hits":        stats.Hits,
+		"misses":      stats.Misses,
+		"comixifiers": stats.Comixifiers,
+	})
+}
+
+// readNoCache tells whether the client asks to run the transform even if its result is cached,
+// with the noCache query parameter or Cache-Control: no-cache.
+func readNoCache(r *http.Request) (bool, *apiError) {
+	if r.URL.Query().Has("noCache") {
+		noCache, err := strconv.ParseBool(r.URL.Query().Get("noCache"))
+		if err != nil {
+			return false, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("noCache must be a boolean, got %q", r.URL.Query().Get("noCache")),
+			)
+		}
+		return noCache, nil
+	}
+
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cachedResultHold is how long the cached result is kept for the transform finishing with it,
+// which keeps the result for its own retention then.
+const cachedResultHold = 10 * time.Minute
+
+// cachedResult returns the cached result of the job input or nil if there is none. The result is kept
+// before it's returned, so garbage collection doesn't remove it meanwhile. Results being collected
+// are misses. Lookup failures are logged and treated as misses, the transform just runs then.
+func (s *Server) cachedResult(ctx context.Context, job *state.Job) *cache.Result {
+	if !s.cache.Enabled() || job.InputInfo == nil || job.InputInfo.SHA256 == "" {
+		return nil
+	}
+
+	key := cache.Key(job.InputInfo.SHA256, job.Comixifier, job.Options)
+	result, err := s.cache.Get(ctx, key)
+	if err != nil {
+		log.Printf("cache: get result: %s\n", err.Error())
+		return nil
+	}
+
+	if result != nil {
+		err = s.transforms.KeepObject(ctx, result.File, time.Now().Add(cachedResultHold))
+		if err == nil {
+			_, err = s.images.StatObject(ctx, s.bucket, result.File, minio.StatObjectOptions{})
+		}
+		if errors.Is(err, state.ErrObjectCollected) || minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			err = s.cache.Delete(ctx, key)
+			if err != nil {
+				log.Printf("cache: delete result: %s\n", err.Error())
+			}
+			result = nil
+		} else if err != nil {
+			log.Printf("cache: check result: %s\n", err.Error())
+			return nil
+		}
+	}
+
+	err = s.cache.Count(ctx, job.Comixifier, result != nil)
+	if err != nil {
+		log.Printf("cache: %s\n", err.Error())
+	}
+	return result
+}
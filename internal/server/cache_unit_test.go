@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadNoCache_Unit(t *testing.T) {
+	tests := []struct {
+		target       string
+		cacheControl string
+		want         bool
+	}{
+		{target: "/v2/transforms", want: false},
+		{target: "/v2/transforms", cacheControl: "max-age=0, No-Cache", want: true},
+		{target: "/v2/transforms?noCache=true", want: true},
+		{target: "/v2/transforms?noCache=false", cacheControl: "no-cache", want: false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, test.target, nil)
+		r.Header.Set("Cache-Control", test.cacheControl)
+		got, apiErr := readNoCache(r)
+		if apiErr != nil || got != test.want {
+			t.Logf("%s %q: no cache got: %t, %v; expected: %t", test.target, test.cacheControl, got, apiErr, test.want)
+			t.FailNow()
+		}
+	}
+
+	_, apiErr := readNoCache(httptest.NewRequest(http.MethodPost, "/v2/transforms?noCache=maybe", nil))
+	if apiErr == nil || apiErr.Code != CodeInvalidRequest {
+		t.Logf("error got: %v; expected: %s", apiErr, CodeInvalidRequest)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,542 @@
+package server
+
+import (
+	"bytes"
+	"comixifier/internal/imaging"
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	v2ComparisonsPath = "/v2/comparisons/"
+	// compositeMaxHeight limits the height of the side-by-side composite of comparison results.
+	compositeMaxHeight = 1024
+)
+
+// comparisonRequest is a new comparison asked by a client.
+type comparisonRequest struct {
+	comixifiers []string
+	// image holds the compared image and options keyed by comixifier name.
+	image *transformRequest
+}
+
+// comparisonItemState is a comparison item along with the state of its transform.
+type comparisonItemState struct {
+	Comixifier  string            `json:"comixifier"`
+	TransformId string            `json:"transformId,omitempty"`
+	Status      state.Status      `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Links       map[string]string `json:"links,omitempty"`
+}
+
+// handleV2Comparisons serves POST /v2/comparisons. The body is the same as of POST /v2/transforms,
+// but the image is transformed by every comixifier of the comixifiers list, by all of them by default,
+// and options are keyed by comixifier name.
+func (s *Server) handleV2Comparisons(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	noCache, apiErr := readNoCache(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	retention, apiErr := readRetention(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	req := &comparisonRequest{
+		comixifiers: splitList(r.URL.Query().Get("comixifiers")),
+		image:       &transformRequest{noCache: noCache, retention: retention, limitHeaders: w.Header()},
+	}
+	cleanup, apiErr := readComparisonRequest(r, req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	defer cleanup()
+
+	comparison, apiErr := s.createComparison(r.Context(), req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	items, apiErr := s.comparisonItems(r.Context(), comparison)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Location", v2ComparisonsPath+comparison.ComparisonId)
+	writeJSON(w, http.StatusAccepted, comparisonResource(comparison, items))
+}
+
+// handleV2Comparison serves /v2/comparisons/{id} and its composite result.
+func (s *Server) handleV2Comparison(w http.ResponseWriter, r *http.Request) {
+	comparisonId, subresource := splitTransformPath(strings.TrimPrefix(r.URL.Path, v2ComparisonsPath))
+	if comparisonId == "" || (subresource != "" && subresource != "result") {
+		writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "not found"))
+		return
+	}
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	comparison, err := s.transforms.Comparison(r.Context(), comparisonId)
+	if err == nil && !ownedBy(r.Context(), comparison.KeyId) {
+		err = state.ErrComparisonNotFound
+	}
+	if errors.Is(err, state.ErrComparisonNotFound) {
+		writeError(w, newApiError(http.StatusNotFound, CodeComparisonNotFound, "comparison not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("comparison: get comparison from state storage: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	items, apiErr := s.comparisonItems(r.Context(), comparison)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	if subresource == "" {
+		writeJSON(w, http.StatusOK, comparisonResource(comparison, items))
+		return
+	}
+	s.writeComparisonResult(w, r, comparison, items)
+}
+
+// readComparisonRequest fills req with the image, comixifiers and options of the request.
+// The returned cleanup removes temp files of the multipart body.
+func readComparisonRequest(r *http.Request, req *comparisonRequest) (func(), *apiError) {
+	noCleanup := func() {}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		reqBody := struct {
+			Comixifiers []string               `json:"comixifiers"`
+			Options     map[string]interface{} `json:"options"`
+			Input       *objectRef             `json:"input"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&reqBody)
+		if err != nil {
+			return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "request body is not valid json: "+err.Error())
+		}
+		if reqBody.Input == nil || reqBody.Input.Key == "" {
+			return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "input.key is required")
+		}
+		if len(reqBody.Comixifiers) > 0 {
+			req.comixifiers = reqBody.Comixifiers
+		}
+		req.image.options = reqBody.Options
+		req.image.input = reqBody.Input
+		return noCleanup, nil
+	}
+
+	cleanup, apiErr := readImageRequest(r, req.image)
+	if apiErr != nil {
+		return noCleanup, apiErr
+	}
+	if r.MultipartForm != nil {
+		if values := r.MultipartForm.Value["comixifiers"]; len(values) > 0 {
+			req.comixifiers = splitList(values[0])
+		}
+	}
+	return cleanup, nil
+}
+
+// comparisonOptions splits options keyed by comixifier name into options of every comixifier.
+func comparisonOptions(providers []*registry.Provider, options map[string]interface{}) (map[string]map[string]interface{}, *apiError) {
+	compared := make(map[string]bool, len(providers))
+	for _, provider := range providers {
+		compared[provider.Name] = true
+	}
+
+	byProvider := make(map[string]map[string]interface{}, len(options))
+	for name, rawOptions := range options {
+		if !compared[name] {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidOptions,
+				fmt.Sprintf("options are given for %q, which isn't compared", name),
+			)
+		}
+		if rawOptions == nil {
+			continue
+		}
+		providerOptions, ok := rawOptions.(map[string]interface{})
+		if !ok {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidOptions,
+				fmt.Sprintf("options of %s must be a JSON object", name),
+			)
+		}
+		byProvider[name] = providerOptions
+	}
+
+	for _, provider := range providers {
+		_, err := provider.ParseOptions(byProvider[provider.Name])
+		if err != nil {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidOptions, provider.Name+": "+err.Error())
+		}
+	}
+	return byProvider, nil
+}
+
+// comparedProviders returns providers of the comixifier names without duplicates or all providers if there are no names.
+func comparedProviders(names []string) ([]*registry.Provider, *apiError) {
+	if len(names) == 0 {
+		return registry.List(), nil
+	}
+
+	providers := make([]*registry.Provider, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		provider, ok := registry.Get(name)
+		if !ok {
+			return nil, newApiError(http.StatusBadRequest, CodeUnknownComixifier,
+				fmt.Sprintf("unknown comixifier: %q", name),
+			)
+		}
+		if seen[provider.Name] {
+			continue
+		}
+		seen[provider.Name] = true
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// createComparison creates a transform of the image by every compared comixifier. The image is stored once
+// and every transform gets its own copy of it. Comixifiers which reject the image don't fail the comparison,
+// they are recorded as failed items.
+func (s *Server) createComparison(ctx context.Context, req *comparisonRequest) (*state.Comparison, *apiError) {
+	providers, apiErr := comparedProviders(req.comixifiers)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if len(providers) == 0 {
+		return nil, newApiError(http.StatusBadRequest, CodeUnknownComixifier, "no comixifiers to compare")
+	}
+	options, apiErr := comparisonOptions(providers, req.image.options)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	comparisonId, err := uuid.NewUUID()
+	if err != nil {
+		log.Printf("comparison: generate uuid: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	comparison := &state.Comparison{
+		ComparisonId: comparisonId.String(),
+		Items:        make([]*state.ComparisonItem, 0, len(providers)),
+		CreatedAt:    time.Now().UTC(),
+		KeyId:        requestKeyId(ctx),
+	}
+
+	input := req.image.input
+	if input == nil {
+		input, apiErr = s.stageComparisonInput(ctx, comparison.ComparisonId, providers, req.image)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	} else {
+		// The upload can't be taken by the first transform, the others need it too.
+		input.copy = (input.Bucket == "" || input.Bucket == s.bucket) && strings.HasPrefix(input.Key, uploadPrefix)
+	}
+
+	for _, provider := range providers {
+		item := &state.ComparisonItem{Comixifier: provider.Name}
+		job, apiErr := s.createTransform(ctx, &transformRequest{
+			comixifier:   provider.Name,
+			options:      options[provider.Name],
+			input:        input,
+			groupId:      comparison.ComparisonId,
+			noFallback:   true,
+			noCache:      req.image.noCache,
+			retention:    req.image.retention,
+			limitHeaders: req.image.limitHeaders,
+		})
+		if apiErr != nil {
+			item.Error = apiErr.Error()
+		} else {
+			item.TransformId = job.TransformId
+		}
+		comparison.Items = append(comparison.Items, item)
+	}
+	if input.copy {
+		s.removeObject(input.Key)
+	}
+
+	err = s.transforms.CreateComparison(ctx, comparison)
+	if err != nil {
+		log.Printf("comparison: create comparison in state storage: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	return comparison, nil
+}
+
+// stageComparisonInput puts the image of the request into the image storage for the compared providers to copy it.
+// Limits of each provider are checked when its transform is created.
+func (s *Server) stageComparisonInput(
+	ctx context.Context,
+	comparisonId string,
+	providers []*registry.Provider,
+	req *transformRequest,
+) (*objectRef, *apiError) {
+	if req.imageSize == 0 {
+		return nil, errEmptyInput()
+	}
+
+	// The largest limit, so every provider which may take the image gets it.
+	var maxInputSize int64
+	for _, provider := range providers {
+		if provider.MaxInputSize == 0 {
+			maxInputSize = 0
+			break
+		}
+		if provider.MaxInputSize > maxInputSize {
+			maxInputSize = provider.MaxInputSize
+		}
+	}
+	if maxInputSize > 0 && req.imageSize > maxInputSize {
+		return nil, errComparisonInputTooLarge(maxInputSize)
+	}
+
+	info, image, err := imaging.Sniff(req.image)
+	if errors.Is(err, imaging.ErrEmpty) {
+		return nil, errEmptyInput()
+	}
+	if errors.Is(err, imaging.ErrUnknownFormat) {
+		return nil, newApiError(http.StatusUnsupportedMediaType, CodeUnsupportedImageFormat, err.Error())
+	}
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	}
+
+	sizeReader := imaging.NewSizeReader(image, maxInputSize)
+	uploadInfo, err := s.images.PutObject(
+		ctx,
+		s.bucket,
+		"input_"+comparisonId,
+		sizeReader,
+		req.imageSize,
+		minio.PutObjectOptions{
+			ContentType: info.ContentType,
+		},
+	)
+	if sizeReader.Exceeded() {
+		if err == nil {
+			s.removeObject(uploadInfo.Key)
+		}
+		return nil, errComparisonInputTooLarge(maxInputSize)
+	}
+	if err != nil {
+		log.Printf("comparison: upload input image to image storage: %s\n", err.Error())
+		return nil, errInternal()
+	}
+
+	return &objectRef{Bucket: s.bucket, Key: uploadInfo.Key, copy: true, staged: true}, nil
+}
+
+// comparisonItems returns the current state of every item of the comparison.
+func (s *Server) comparisonItems(ctx context.Context, comparison *state.Comparison) ([]*comparisonItemState, *apiError) {
+	items := make([]*comparisonItemState, 0, len(comparison.Items))
+	for _, item := range comparison.Items {
+		status, itemErr, apiErr := s.itemState(ctx, item.TransformId, item.Error)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		itemState := &comparisonItemState{
+			Comixifier:  item.Comixifier,
+			TransformId: item.TransformId,
+			Status:      status,
+			Error:       itemErr,
+		}
+		if item.TransformId != "" {
+			itemState.Links = map[string]string{
+				"transform": v2TransformsPath + item.TransformId,
+			}
+			if status == state.StatusFinish {
+				itemState.Links["result"] = v2TransformsPath + item.TransformId + "/result"
+			}
+		}
+		items = append(items, itemState)
+	}
+	return items, nil
+}
+
+// comparisonResource is a representation of the comparison in the v2 API.
+func comparisonResource(comparison *state.Comparison, items []*comparisonItemState) map[string]interface{} {
+	done, failed, pending := 0, 0, 0
+	for _, item := range items {
+		switch {
+		case item.Status == state.StatusFinish:
+			done++
+		case item.Status.IsFinal():
+			failed++
+		default:
+			pending++
+		}
+	}
+
+	links := map[string]string{
+		"self": v2ComparisonsPath + comparison.ComparisonId,
+	}
+	if pending == 0 && done > 0 {
+		links["result"] = v2ComparisonsPath + comparison.ComparisonId + "/result"
+	}
+
+	return map[string]interface{}{
+		"comparisonId": comparison.ComparisonId,
+		"createdAt":    comparison.CreatedAt,
+		"total":        len(items),
+		"done":         done,
+		"failed":       failed,
+		"pending":      pending,
+		"items":        items,
+		"links":        links,
+	}
+}
+
+// writeComparisonResult sends the composite of results of the finished comparison items,
+// composing and storing it on the first request.
+func (s *Server) writeComparisonResult(w http.ResponseWriter, r *http.Request, comparison *state.Comparison, items []*comparisonItemState) {
+	format, redirect, apiErr := readResultParams(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	finished := make([]*comparisonItemState, 0, len(items))
+	for _, item := range items {
+		if !item.Status.IsFinal() {
+			writeError(w, newApiError(http.StatusConflict, CodeComparisonNotFinished, "comparison has pending transforms"))
+			return
+		}
+		if item.Status == state.StatusFinish {
+			finished = append(finished, item)
+		}
+	}
+	if len(finished) == 0 {
+		writeError(w, newApiError(http.StatusConflict, CodeComparisonFailed, "no comixifier produced a result"))
+		return
+	}
+
+	key, apiErr := s.comparisonComposite(r.Context(), comparison.ComparisonId, finished)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	s.writeObject(w, r, key, format, redirect)
+}
+
+// comparisonComposite returns the key of the composite of the items, composing and storing it unless it's stored already.
+func (s *Server) comparisonComposite(ctx context.Context, comparisonId string, items []*comparisonItemState) (string, *apiError) {
+	key := compositeKey(comparisonId)
+	_, err := s.images.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return key, nil
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		log.Printf("comparison: stat composite in storage: %s\n", err.Error())
+		return "", errInternal()
+	}
+
+	images := make([]image.Image, 0, len(items))
+	labels := make([]string, 0, len(items))
+	for _, item := range items {
+		img, err := s.resultImage(ctx, item.TransformId)
+		if err != nil {
+			log.Printf("comparison: get result of %s: %s\n", item.TransformId, err.Error())
+			return "", errInternal()
+		}
+		images = append(images, img)
+		labels = append(labels, item.Comixifier)
+	}
+
+	composite := &bytes.Buffer{}
+	err = imaging.Encode(composite, imaging.Composite(images, labels, compositeMaxHeight), "png", 0)
+	if err != nil {
+		log.Printf("comparison: encode composite: %s\n", err.Error())
+		return "", errInternal()
+	}
+
+	_, err = s.images.PutObject(ctx, s.bucket, key, composite, int64(composite.Len()), minio.PutObjectOptions{
+		ContentType: imaging.ContentType("png"),
+	})
+	if err != nil {
+		log.Printf("comparison: upload composite to storage: %s\n", err.Error())
+		return "", errInternal()
+	}
+
+	err = s.transforms.KeepObject(ctx, key, time.Now().Add(state.GroupTTL))
+	if err != nil {
+		log.Printf("comparison: retain composite: %s\n", err.Error())
+	}
+	return key, nil
+}
+
+// resultImage decodes the result image of the finished transform.
+func (s *Server) resultImage(ctx context.Context, transformId string) (image.Image, error) {
+	key, err := s.transforms.File(ctx, transformId)
+	if err != nil {
+		return nil, fmt.Errorf("get result key: %w", err)
+	}
+
+	result, err := s.images.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get result: %w", err)
+	}
+	defer result.Close()
+
+	img, _, err := image.Decode(result)
+	if err != nil {
+		return nil, fmt.Errorf("decode result: %w", err)
+	}
+	return img, nil
+}
+
+// compositeKey is the image storage key of the composite of the comparison.
+func compositeKey(comparisonId string) string {
+	return "comparison_" + comparisonId + ".png"
+}
+
+// splitList splits a comma-separated list skipping empty elements.
+func splitList(raw string) []string {
+	var list []string
+	for _, element := range strings.Split(raw, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+func errComparisonInputTooLarge(maxInputSize int64) *apiError {
+	return newApiError(http.StatusRequestEntityTooLarge, CodeInputTooLarge,
+		fmt.Sprintf("compared comixifiers accept images up to %d bytes", maxInputSize),
+	)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"testing"
+)
+
+func TestComparisonOptions_Unit(t *testing.T) {
+	providers := []*registry.Provider{
+		{Name: "first", Options: []registry.Option{{Name: "style", Type: registry.OptionTypeString}}},
+		{Name: "second"},
+	}
+
+	options, apiErr := comparisonOptions(providers, map[string]interface{}{
+		"first":  map[string]interface{}{"style": "ink"},
+		"second": nil,
+	})
+	if apiErr != nil {
+		t.Logf("error got: %s; expected: <nil>", apiErr.Error())
+		t.FailNow()
+	}
+	if options["first"]["style"] != "ink" || options["second"] != nil {
+		t.Logf("options got: %v; expected: first with style ink only", options)
+		t.FailNow()
+	}
+
+	invalid := []map[string]interface{}{
+		{"third": map[string]interface{}{}},
+		{"first": "ink"},
+		{"second": map[string]interface{}{"style": "ink"}},
+	}
+	for _, raw := range invalid {
+		_, apiErr = comparisonOptions(providers, raw)
+		if apiErr == nil || apiErr.Code != CodeInvalidOptions {
+			t.Logf("%v: error got: %v; expected: %s", raw, apiErr, CodeInvalidOptions)
+			t.FailNow()
+		}
+	}
+}
+
+func TestComparisonResource_Unit(t *testing.T) {
+	comparison := &state.Comparison{ComparisonId: "comparison_id"}
+	items := []*comparisonItemState{
+		{Comixifier: "cutout", Status: state.StatusFinish},
+		{Comixifier: "face2comics", Status: state.StatusFatal},
+	}
+
+	resource := comparisonResource(comparison, items)
+	if resource["done"] != 1 || resource["failed"] != 1 || resource["pending"] != 0 {
+		t.Logf("progress got: done %v, failed %v, pending %v; expected: 1, 1, 0",
+			resource["done"], resource["failed"], resource["pending"],
+		)
+		t.FailNow()
+	}
+	links := resource["links"].(map[string]string)
+	if links["result"] != "/v2/comparisons/comparison_id/result" {
+		t.Logf("result link got: %q; expected: /v2/comparisons/comparison_id/result", links["result"])
+		t.FailNow()
+	}
+
+	items[1].Status = state.StatusWait
+	links = comparisonResource(comparison, items)["links"].(map[string]string)
+	if _, ok := links["result"]; ok {
+		t.Log("result link of the pending comparison got; expected none")
+		t.FailNow()
+	}
+}
+
+func TestSplitList_Unit(t *testing.T) {
+	list := splitList(" cutout,,face2comics ,")
+	if len(list) != 2 || list[0] != "cutout" || list[1] != "face2comics" {
+		t.Logf("list got: %q; expected: [cutout face2comics]", list)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Error codes let clients tell failures apart without parsing messages.
+const (
+	CodeMethodNotAllowed        = "method_not_allowed"
+	CodeNotFound                = "not_found"
+	CodeInvalidRequest          = "invalid_request"
+	CodeUnknownComixifier       = "unknown_comixifier"
+	CodeInvalidOptions          = "invalid_options"
+	CodeEmptyInput              = "empty_input"
+	CodeInputTooLarge           = "input_too_large"
+	CodeUnsupportedImageFormat  = "unsupported_image_format"
+	CodeInvalidDimensions       = "invalid_dimensions"
+	CodeInvalidCallbackURL      = "invalid_callback_url"
+	CodeInputNotFound           = "input_not_found"
+	CodeInputBucketNotAllowed   = "input_bucket_not_allowed"
+	CodeInputNotAllowed         = "input_not_allowed"
+	CodeUnsupportedOutputFormat = "unsupported_output_format"
+	CodeTransformNotFound       = "transform_not_found"
+	CodeTransformNotFinished    = "transform_not_finished"
+	CodeTransformCompleted      = "transform_completed"
+	CodeRenditionNotFound       = "rendition_not_found"
+	CodeInvalidArchive          = "invalid_archive"
+	CodeBatchTooLarge           = "batch_too_large"
+	CodeBatchNotFound           = "batch_not_found"
+	CodeBatchNotFinished        = "batch_not_finished"
+	CodeComparisonNotFound      = "comparison_not_found"
+	CodeComparisonNotFinished   = "comparison_not_finished"
+	CodeComparisonFailed        = "comparison_failed"
+	CodeUnauthorized            = "unauthorized"
+	CodeApiKeyNotFound          = "api_key_not_found"
+	CodeRateLimited             = "rate_limited"
+	CodeCollectionRunning       = "collection_running"
+	CodeInternal                = "internal_error"
+)
+
+type apiError struct {
+	status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newApiError(status int, code string, message string) *apiError {
+	return &apiError{
+		status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func errInternal() *apiError {
+	return newApiError(http.StatusInternalServerError, CodeInternal, "internal error")
+}
+
+func errTransformNotFound() *apiError {
+	return newApiError(http.StatusNotFound, CodeTransformNotFound, "transform not found")
+}
+
+// writeError sends the error as {"error": {"code": "...", "message": "..."}}.
+func writeError(w http.ResponseWriter, e *apiError) {
+	writeJSON(w, e.status, map[string]interface{}{
+		"error": e,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("server: marshal resp body to json: %s\n", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonBody)
+}
+
+// allowMethods responds with 405 unless the request uses one of methods.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, newApiError(http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+		"method "+r.Method+" is not allowed",
+	))
+	return false
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"comixifier/internal/state"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const keepAliveInterval = 15 * time.Second
+
+// streamEvents sends every state change of the transform as a server-sent event
+// until the transform is completed, the client goes away or the server shuts down.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, transformId string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, newApiError(http.StatusInternalServerError, CodeInternal, "streaming is not supported"))
+		return
+	}
+
+	// Subscribe before reading the current state so no change in between is lost.
+	subscription, err := s.transforms.Subscribe(r.Context(), transformId)
+	if err != nil {
+		log.Printf("events: subscribe to transform events: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	defer subscription.Close()
+
+	event, err := s.transforms.Snapshot(r.Context(), transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		writeError(w, errTransformNotFound())
+		return
+	}
+	if err != nil {
+		log.Printf("events: get transform state: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		err = writeEvent(w, event)
+		if err != nil {
+			log.Printf("events: write event: %s\n", err.Error())
+			return
+		}
+		flusher.Flush()
+
+		if event.Status.IsFinal() {
+			return
+		}
+
+		event = nil
+		for event == nil {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.closing:
+				return
+			case <-keepAlive.C:
+				_, err = io.WriteString(w, ": keep-alive\n\n")
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			case event, ok = <-subscription.Events():
+				if !ok {
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeEvent sends the transform state as a server-sent event.
+func writeEvent(w io.Writer, event *state.Event) error {
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event to json: %w", err)
+	}
+
+	_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", jsonEvent)
+	return err
+}
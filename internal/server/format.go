@@ -0,0 +1,176 @@
+package server
+
+import (
+	"comixifier/internal/imaging"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// resultFormat is a format of the result asked by a client.
+type resultFormat struct {
+	format  string
+	quality int
+}
+
+// readResultFormat reads the format and quality query parameters or negotiates the format by Accept.
+// The empty format means the stored result as is.
+func readResultFormat(r *http.Request) (*resultFormat, *apiError) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format != "" && !imaging.IsOutputFormat(format) {
+		return nil, newApiError(http.StatusBadRequest, CodeUnsupportedOutputFormat,
+			fmt.Sprintf("unsupported format: %q, supported: %s", format, strings.Join(imaging.OutputFormats, ", ")),
+		)
+	}
+	if format == "" {
+		format = negotiateFormat(r.Header.Get("Accept"))
+	}
+
+	quality := imaging.DefaultJPEGQuality
+	if query.Has("quality") {
+		var err error
+		quality, err = strconv.Atoi(query.Get("quality"))
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("quality must be an integer from 1 to 100, got %q", query.Get("quality")),
+			)
+		}
+	}
+
+	return &resultFormat{
+		format:  format,
+		quality: quality,
+	}, nil
+}
+
+// negotiateFormat picks the output format most preferred by the Accept header.
+// Wildcards are satisfied by the stored result, so they yield the empty format
+// unless a concrete type is preferred over them.
+func negotiateFormat(accept string) string {
+	if accept == "" {
+		return ""
+	}
+
+	best, bestQ := "", 0.0
+	wildcardQ := -1.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if rawQ, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(rawQ, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if mediaType == "*/*" || mediaType == "image/*" {
+			if q > wildcardQ {
+				wildcardQ = q
+			}
+			continue
+		}
+		if !strings.HasPrefix(mediaType, "image/") {
+			continue
+		}
+		format := strings.TrimPrefix(mediaType, "image/")
+		if imaging.IsOutputFormat(format) && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+
+	if wildcardQ >= bestQ {
+		return ""
+	}
+	return best
+}
+
+// readResultParams reads how the client wants to get a result: its format and whether to redirect.
+func readResultParams(r *http.Request) (*resultFormat, bool, *apiError) {
+	format, apiErr := readResultFormat(r)
+	if apiErr != nil {
+		return nil, false, apiErr
+	}
+
+	redirect := false
+	if r.URL.Query().Has("redirect") {
+		var err error
+		redirect, err = strconv.ParseBool(r.URL.Query().Get("redirect"))
+		if err != nil {
+			return nil, false, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("redirect must be a boolean, got %q", r.URL.Query().Get("redirect")),
+			)
+		}
+	}
+
+	return format, redirect, nil
+}
+
+// variantKey is the image storage key of the result transcoded to the format.
+func variantKey(key string, f *resultFormat) string {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	if f.format == "jpeg" {
+		return fmt.Sprintf("%s_q%d.jpeg", base, f.quality)
+	}
+	return base + "." + f.format
+}
+
+// resultVariant returns the key of the result in the format, transcoding and caching it on the first request.
+func (s *Server) resultVariant(ctx context.Context, key string, f *resultFormat) (string, *apiError) {
+	if f.format == "" || path.Ext(key) == "."+f.format {
+		return key, nil
+	}
+
+	variant := variantKey(key, f)
+	_, err := s.images.StatObject(ctx, s.bucket, variant, minio.StatObjectOptions{})
+	if err == nil {
+		return variant, nil
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		log.Printf("download: stat result variant in storage: %s\n", err.Error())
+		return "", errInternal()
+	}
+
+	original, err := s.images.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("download: get file from storage: %s\n", err.Error())
+		return "", errInternal()
+	}
+	defer original.Close()
+
+	_, err = original.Stat()
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return "", errTransformNotFound()
+	}
+	if err != nil {
+		log.Printf("download: stat file in storage: %s\n", err.Error())
+		return "", errInternal()
+	}
+
+	transcoded, err := imaging.Transcode(original, f.format, f.quality)
+	if err != nil {
+		log.Printf("download: transcode result to %s: %s\n", f.format, err.Error())
+		return "", errInternal()
+	}
+
+	_, err = s.images.PutObject(ctx, s.bucket, variant, transcoded, int64(transcoded.Len()), minio.PutObjectOptions{
+		ContentType: imaging.ContentType(f.format),
+	})
+	if err != nil {
+		log.Printf("download: upload result variant to storage: %s\n", err.Error())
+		return "", errInternal()
+	}
+
+	return variant, nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat_Unit(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "*/*", want: ""},
+		{accept: "image/jpeg", want: "jpeg"},
+		{accept: "image/webp,image/*;q=0.8", want: ""},
+		{accept: "image/gif;q=0.5, image/jpeg;q=0.9, */*;q=0.1", want: "jpeg"},
+		{accept: "image/png, image/jpeg", want: "png"},
+		{accept: "application/json", want: ""},
+	}
+
+	for _, test := range tests {
+		got := negotiateFormat(test.accept)
+		if got != test.want {
+			t.Logf("%q: format got: %q; expected: %q", test.accept, got, test.want)
+			t.FailNow()
+		}
+	}
+}
+
+func TestReadResultFormat_Unit(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v2/transforms/id/result?format=jpeg&quality=70", nil)
+	r.Header.Set("Accept", "image/gif")
+	f, apiErr := readResultFormat(r)
+	if apiErr != nil {
+		t.Logf("error got: %s; expected: <nil>", apiErr.Error())
+		t.FailNow()
+	}
+	if f.format != "jpeg" || f.quality != 70 {
+		t.Logf("format got: %+v; expected: jpeg 70", f)
+		t.FailNow()
+	}
+	if key := variantKey("img_1_2_3.png", f); key != "img_1_2_3_q70.jpeg" {
+		t.Logf("variant key got: %s; expected: img_1_2_3_q70.jpeg", key)
+		t.FailNow()
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/v2/transforms/id/result?format=bmp", nil)
+	_, apiErr = readResultFormat(r)
+	if apiErr == nil || apiErr.Code != CodeUnsupportedOutputFormat {
+		t.Logf("error got: %v; expected: %s", apiErr, CodeUnsupportedOutputFormat)
+		t.FailNow()
+	}
+}
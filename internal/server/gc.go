@@ -0,0 +1,47 @@
+package server
+
+import (
+	"comixifier/internal/gc"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+)
+
+const adminGCPath = "/admin/gc"
+
+// handleAdminGC serves GET /admin/gc, which returns the report of the last garbage collection,
+// and POST /admin/gc, which collects garbage of the image storage right away. The collection isn't
+// tied to the request, so a client going away doesn't stop it halfway.
+func (s *Server) handleAdminGC(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) || !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		report, err := s.collector.LastReport(r.Context())
+		if err != nil {
+			log.Printf("gc: get last report: %s\n", err.Error())
+			writeError(w, errInternal())
+			return
+		}
+		if report == nil {
+			writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "garbage has not been collected yet"))
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	report, err := s.collector.Collect(context.Background())
+	if errors.Is(err, gc.ErrRunning) {
+		writeError(w, newApiError(http.StatusConflict, CodeCollectionRunning, err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("gc: collect garbage: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
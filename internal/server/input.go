@@ -0,0 +1,113 @@
+package server
+
+import (
+	"comixifier/internal/imaging"
+	"comixifier/internal/registry"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// uploadInput checks the image of the request and puts it into the image storage.
+// It returns a key of the stored image and what the image is.
+func (s *Server) uploadInput(
+	ctx context.Context,
+	transformId string,
+	provider *registry.Provider,
+	req *transformRequest,
+) (string, *imaging.Info, *apiError) {
+	if req.imageSize == 0 {
+		return "", nil, errEmptyInput()
+	}
+	if provider.MaxInputSize > 0 && req.imageSize > provider.MaxInputSize {
+		return "", nil, errInputTooLarge(provider)
+	}
+
+	info, image, apiErr := checkImage(provider, req.image)
+	if apiErr != nil {
+		return "", nil, apiErr
+	}
+
+	hash := sha256.New()
+	sizeReader := imaging.NewSizeReader(io.TeeReader(image, hash), provider.MaxInputSize)
+	uploadInfo, err := s.images.PutObject(
+		ctx,
+		s.bucket,
+		"input_"+transformId,
+		sizeReader,
+		req.imageSize,
+		minio.PutObjectOptions{
+			ContentType: info.ContentType,
+		},
+	)
+	if sizeReader.Exceeded() {
+		if err == nil {
+			s.removeObject(uploadInfo.Key)
+		}
+		return "", nil, errInputTooLarge(provider)
+	}
+	if err != nil {
+		log.Printf("transform: upload input image to image storage: %s\n", err.Error())
+		return "", nil, errInternal()
+	}
+
+	info.Size = sizeReader.Size()
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return uploadInfo.Key, info, nil
+}
+
+// checkImage sniffs the image and checks that the provider can take it.
+// The returned reader yields the whole image.
+func checkImage(provider *registry.Provider, r io.Reader) (*imaging.Info, io.Reader, *apiError) {
+	info, image, err := imaging.Sniff(r)
+	if errors.Is(err, imaging.ErrEmpty) {
+		return nil, nil, errEmptyInput()
+	}
+	if errors.Is(err, imaging.ErrUnknownFormat) {
+		return nil, nil, newApiError(http.StatusUnsupportedMediaType, CodeUnsupportedImageFormat, err.Error())
+	}
+	if err != nil {
+		return nil, nil, newApiError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	}
+
+	if !provider.Accepts(info.ContentType) {
+		return nil, nil, newApiError(http.StatusUnsupportedMediaType, CodeUnsupportedImageFormat,
+			fmt.Sprintf("%s doesn't accept %s images, accepted: %v", provider.Name, info.Format, provider.InputFormats),
+		)
+	}
+
+	err = imaging.DefaultLimits.Check(info)
+	if err != nil {
+		return nil, nil, newApiError(http.StatusBadRequest, CodeInvalidDimensions, err.Error())
+	}
+
+	return info, image, nil
+}
+
+func (s *Server) removeObject(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := s.images.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		log.Printf("server: remove object %s from image storage: %s\n", key, err.Error())
+	}
+}
+
+func errEmptyInput() *apiError {
+	return newApiError(http.StatusBadRequest, CodeEmptyInput, "image is empty")
+}
+
+func errInputTooLarge(provider *registry.Provider) *apiError {
+	return newApiError(http.StatusRequestEntityTooLarge, CodeInputTooLarge,
+		fmt.Sprintf("%s accepts images up to %d bytes", provider.Name, provider.MaxInputSize),
+	)
+}
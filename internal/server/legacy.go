@@ -0,0 +1,167 @@
+package server
+
+import (
+	"comixifier/internal/state"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Handlers of the first API version. They are kept for compatibility with
+// existing clients: transforms are referenced by transformId in JSON bodies.
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// Download links in webhooks pass transformId in the query.
+	transformId := r.URL.Query().Get("transformId")
+	if transformId == "" {
+		var apiErr *apiError
+		transformId, apiErr = readTransformId(r)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+	}
+	apiErr := s.authorizeTransform(r.Context(), transformId)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	s.writeResult(w, r, transformId)
+}
+
+func (s *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	transformId, apiErr := readTransformId(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	apiErr = s.authorizeTransform(r.Context(), transformId)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	event, apiErr := s.transformState(r.Context(), transformId)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"status": event.Status,
+		"stage":  event.Stage,
+		"error":  event.Error,
+	}
+	if event.Status == state.StatusFinish {
+		renditions, apiErr := s.transformRenditions(r.Context(), transformId)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		resp["renditions"] = renditionResources(transformId, renditions)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleTransform(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	noCache, apiErr := readNoCache(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	req := &transformRequest{
+		comixifier:   r.Header.Get("Comixifier-Name"),
+		callbackURL:  r.Header.Get("Callback-URL"),
+		noCache:      noCache,
+		limitHeaders: w.Header(),
+	}
+	cleanup, apiErr := readImageRequest(r, req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	defer cleanup()
+
+	job, apiErr := s.createTransform(r.Context(), req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"transformId": job.TransformId,
+	})
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodDelete) {
+		return
+	}
+
+	transformId := strings.TrimPrefix(r.URL.Path, "/transform/")
+	apiErr := s.authorizeTransform(r.Context(), transformId)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	apiErr = s.cancelTransform(r.Context(), transformId)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"transformId": transformId,
+		"status":      "CANCELLED",
+	})
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	transformId := strings.TrimPrefix(r.URL.Path, "/transforms/")
+	if !strings.HasSuffix(transformId, "/events") {
+		writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "not found"))
+		return
+	}
+	transformId = strings.TrimSuffix(transformId, "/events")
+
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	apiErr := s.authorizeTransform(r.Context(), transformId)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	s.streamEvents(w, r, transformId)
+}
+
+// readTransformId reads {"transformId": "..."} request body.
+func readTransformId(r *http.Request) (string, *apiError) {
+	rawReqBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", newApiError(http.StatusBadRequest, CodeInvalidRequest, "read request body: "+err.Error())
+	}
+
+	reqBody := map[string]string{
+		"transformId": "",
+	}
+	err = json.Unmarshal(rawReqBody, &reqBody)
+	if err != nil {
+		return "", newApiError(http.StatusBadRequest, CodeInvalidRequest, "request body is not valid json: "+err.Error())
+	}
+	if reqBody["transformId"] == "" {
+		return "", newApiError(http.StatusBadRequest, CodeInvalidRequest, "transformId is required")
+	}
+
+	return reqBody["transformId"], nil
+}
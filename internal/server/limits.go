@@ -0,0 +1,186 @@
+package server
+
+import (
+	"comixifier/internal/limit"
+	"comixifier/internal/registry"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminLimitsPath = "/admin/limits"
+
+// takeLimit counts a new transform against limits of the request key and checks that the provider isn't
+// used up, calls of providers are counted by workers. Cached and followed transforms call no provider.
+// Limit headers of the decision are set on header unless it's nil. The decision is given to refundLimit
+// if the transform doesn't start after all, it's nil if nothing is limited.
+func (s *Server) takeLimit(ctx context.Context, provider string, header http.Header) (*limit.Decision, *apiError) {
+	if s.limiter == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	decision, err := s.limiter.Take(ctx, requestKeyId(ctx), provider, now)
+	if err != nil {
+		log.Printf("limit: take limits: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	if decision == nil {
+		return nil, nil
+	}
+
+	if header != nil {
+		setLimitHeaders(header, decision, now)
+	}
+	if !decision.Allowed {
+		kind, name := limit.SplitScope(decision.Scope)
+		subject := "api key"
+		if kind == "provider" {
+			subject = "comixifier " + name
+		}
+		return nil, newApiError(http.StatusTooManyRequests, CodeRateLimited,
+			fmt.Sprintf("%s is limited to %d transforms, retry after %s", subject, decision.Limit, decision.Reset.UTC().Format(time.RFC3339)),
+		)
+	}
+	return decision, nil
+}
+
+// refundLimit gives back the transform counted by takeLimit which failed to start.
+func (s *Server) refundLimit(decision *limit.Decision) {
+	if s.limiter == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := s.limiter.Refund(ctx, decision)
+	if err != nil {
+		log.Printf("limit: %s\n", err.Error())
+	}
+}
+
+// setLimitHeaders tells clients about the limit closest to be used up. Retry-After is set once it's used up.
+func setLimitHeaders(header http.Header, decision *limit.Decision, now time.Time) {
+	header.Set("X-RateLimit-Limit", strconv.FormatInt(decision.Limit, 10))
+	header.Set("X-RateLimit-Remaining", strconv.FormatInt(decision.Remaining, 10))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(decision.Reset.Unix(), 10))
+	if !decision.Allowed {
+		header.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(decision.RetryAfter(now).Seconds())), 10))
+	}
+}
+
+// handleAdminLimits serves GET /admin/limits, which lists limits of keys and providers.
+func (s *Server) handleAdminLimits(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) || !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	all, err := s.limiter.All(r.Context())
+	if err != nil {
+		log.Printf("limit: list limits: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	keys := make(map[string]*limit.Limits)
+	providers := make(map[string]*limit.Limits)
+	for scope, limits := range all {
+		kind, name := limit.SplitScope(scope)
+		if kind == "provider" {
+			providers[name] = limits
+		} else {
+			keys[name] = limits
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":      keys,
+		"providers": providers,
+	})
+}
+
+// handleAdminLimit serves PUT and DELETE /admin/limits/keys/{keyId} and /admin/limits/providers/{name},
+// which change limits at runtime. The "default" key id stands for keys without their own limits.
+func (s *Server) handleAdminLimit(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !s.authorizeAdmin(w, r) || !allowMethods(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+
+	scope, apiErr := readLimitScope(strings.TrimPrefix(r.URL.Path, adminLimitsPath+"/"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err := s.limiter.Delete(r.Context(), scope)
+		if errors.Is(err, limit.ErrLimitsNotFound) {
+			writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "limits not found"))
+			return
+		}
+		if err != nil {
+			log.Printf("limit: delete limits: %s\n", err.Error())
+			writeError(w, errInternal())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rawReqBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "read request body: "+err.Error()))
+		return
+	}
+	limits := &limit.Limits{}
+	err = json.Unmarshal(rawReqBody, limits)
+	if err != nil {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "request body is not valid json: "+err.Error()))
+		return
+	}
+	if limits.PerMinute < 0 || limits.PerDay < 0 || limits.PerMonth < 0 {
+		writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, "limits must not be negative"))
+		return
+	}
+
+	err = s.limiter.Set(r.Context(), scope, limits)
+	if err != nil {
+		log.Printf("limit: set limits: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	writeJSON(w, http.StatusOK, limits)
+}
+
+// readLimitScope reads "keys/{keyId}" or "providers/{name}" path.
+func readLimitScope(path string) (string, *apiError) {
+	kind, name := splitTransformPath(path)
+	if name == "" || strings.Contains(name, "/") {
+		return "", newApiError(http.StatusNotFound, CodeNotFound, "not found")
+	}
+
+	switch kind {
+	case "keys":
+		return limit.KeyScope(name), nil
+	case "providers":
+		provider, ok := registry.Get(name)
+		if !ok {
+			return "", newApiError(http.StatusBadRequest, CodeUnknownComixifier,
+				fmt.Sprintf("unknown comixifier: %q", name),
+			)
+		}
+		return limit.ProviderScope(provider.Name), nil
+	default:
+		return "", newApiError(http.StatusNotFound, CodeNotFound, "not found")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"comixifier/internal/limit"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSetLimitHeaders_Unit(t *testing.T) {
+	now := time.Now()
+	reset := now.Add(90 * time.Second)
+
+	header := http.Header{}
+	setLimitHeaders(header, &limit.Decision{Allowed: true, Limit: 10, Remaining: 4, Reset: reset}, now)
+	if header.Get("X-RateLimit-Limit") != "10" || header.Get("X-RateLimit-Remaining") != "4" ||
+		header.Get("X-RateLimit-Reset") != strconv.FormatInt(reset.Unix(), 10) || header.Get("Retry-After") != "" {
+		t.Logf("headers of an allowed transform got: %v", header)
+		t.FailNow()
+	}
+
+	header = http.Header{}
+	setLimitHeaders(header, &limit.Decision{Allowed: false, Limit: 10, Reset: reset}, now)
+	if header.Get("X-RateLimit-Remaining") != "0" || header.Get("Retry-After") != "90" {
+		t.Logf("headers of a limited transform got: %v", header)
+		t.FailNow()
+	}
+}
+
+func TestReadLimitScope_Unit(t *testing.T) {
+	tests := []struct {
+		path     string
+		want     string
+		wantCode string
+	}{
+		{path: "keys/default", want: limit.DefaultKeyScope},
+		{path: "keys/6ba7b810-9dad-11d1-80b4-00c04fd430c8", want: "key:6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{path: "providers/unknown", wantCode: CodeUnknownComixifier},
+		{path: "keys", wantCode: CodeNotFound},
+		{path: "keys/a/b", wantCode: CodeNotFound},
+		{path: "users/a", wantCode: CodeNotFound},
+	}
+	for _, test := range tests {
+		scope, apiErr := readLimitScope(test.path)
+		if test.wantCode != "" {
+			if apiErr == nil || apiErr.Code != test.wantCode {
+				t.Logf("%s: error got: %v; expected: %s", test.path, apiErr, test.wantCode)
+				t.FailNow()
+			}
+			continue
+		}
+		if apiErr != nil || scope != test.want {
+			t.Logf("%s: scope got: %q, %v; expected: %q", test.path, scope, apiErr, test.want)
+			t.FailNow()
+		}
+	}
+}
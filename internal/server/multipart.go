@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// maxMultipartMemory is how much of a multipart body is kept in memory, the rest goes to temp files.
+const maxMultipartMemory = 10 << 20
+
+// readImageRequest fills req with the image and options of the request. The body is either the raw image,
+// multipart/form-data with an image part, an optional options part holding a JSON object
+// and an optional comixifier part, or a JSON object referencing an image in the image storage.
+// The returned cleanup removes temp files of the multipart body.
+func readImageRequest(r *http.Request, req *transformRequest) (func(), *apiError) {
+	noCleanup := func() {}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		return noCleanup, readReferenceRequest(r, req)
+	}
+	if mediaType != "multipart/form-data" {
+		req.image = r.Body
+		req.imageSize = r.ContentLength
+		return noCleanup, nil
+	}
+
+	err := r.ParseMultipartForm(maxMultipartMemory)
+	if err != nil {
+		return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "parse multipart body: "+err.Error())
+	}
+	cleanup := func() {
+		r.MultipartForm.RemoveAll()
+	}
+
+	image, imageHeader, err := r.FormFile("image")
+	if err != nil {
+		cleanup()
+		return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "image part is required")
+	}
+	req.image = image
+	req.imageSize = imageHeader.Size
+	cleanup = func() {
+		image.Close()
+		r.MultipartForm.RemoveAll()
+	}
+
+	if comixifier := r.FormValue("comixifier"); comixifier != "" {
+		req.comixifier = comixifier
+	}
+
+	rawOptions, err := readFormPart(r, "options")
+	if err != nil {
+		cleanup()
+		return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidRequest, "read options part: "+err.Error())
+	}
+	if len(rawOptions) > 0 {
+		err = json.Unmarshal(rawOptions, &req.options)
+		if err != nil {
+			cleanup()
+			return noCleanup, newApiError(http.StatusBadRequest, CodeInvalidOptions,
+				"options part must be a JSON object: "+err.Error(),
+			)
+		}
+	}
+
+	return cleanup, nil
+}
+
+// readFormPart returns a part of the parsed multipart form sent either as a value or as a file.
+func readFormPart(r *http.Request, name string) ([]byte, error) {
+	if values := r.MultipartForm.Value[name]; len(values) > 0 {
+		return []byte(values[0]), nil
+	}
+
+	files := r.MultipartForm.File[name]
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	f, err := files[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
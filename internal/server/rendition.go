@@ -0,0 +1,54 @@
+package server
+
+import (
+	"comixifier/internal/imaging"
+	"comixifier/internal/state"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// renditionKey returns the image storage key of the named rendition of the result.
+// The empty name means the original result.
+func (s *Server) renditionKey(ctx context.Context, transformId string, resultKey string, name string) (string, *apiError) {
+	if name == "" || name == imaging.RenditionOriginal {
+		return resultKey, nil
+	}
+
+	renditions, apiErr := s.transformRenditions(ctx, transformId)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	for _, rendition := range renditions {
+		if rendition.Name == name {
+			return rendition.Key, nil
+		}
+	}
+
+	return "", newApiError(http.StatusNotFound, CodeRenditionNotFound, fmt.Sprintf("rendition not found: %q", name))
+}
+
+func (s *Server) transformRenditions(ctx context.Context, transformId string) ([]*state.Rendition, *apiError) {
+	renditions, err := s.transforms.Renditions(ctx, transformId)
+	if err != nil {
+		log.Printf("download: get renditions from state storage: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	return renditions, nil
+}
+
+// renditionResources lists renditions with download links for clients.
+func renditionResources(transformId string, renditions []*state.Rendition) []map[string]interface{} {
+	resources := make([]map[string]interface{}, 0, len(renditions))
+	for _, rendition := range renditions {
+		resources = append(resources, map[string]interface{}{
+			"name":   rendition.Name,
+			"width":  rendition.Width,
+			"height": rendition.Height,
+			"link":   v2TransformsPath + transformId + "/result?rendition=" + url.QueryEscape(rendition.Name),
+		})
+	}
+	return resources
+}
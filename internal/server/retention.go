@@ -0,0 +1,53 @@
+package server
+
+import (
+	"comixifier/internal/auth"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	minRetention = time.Minute
+	maxRetention = 30 * 24 * time.Hour
+)
+
+// readRetention reads how long the client asks to keep the result, in seconds of the retention
+// query parameter. It's zero if the client doesn't ask.
+func readRetention(r *http.Request) (time.Duration, *apiError) {
+	if !r.URL.Query().Has("retention") {
+		return 0, nil
+	}
+
+	seconds, err := strconv.ParseInt(r.URL.Query().Get("retention"), 10, 64)
+	if err != nil {
+		return 0, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("retention must be an integer number of seconds, got %q", r.URL.Query().Get("retention")),
+		)
+	}
+	return checkRetention(seconds)
+}
+
+// checkRetention converts the retention in seconds, which must be between the minimum and the maximum one.
+func checkRetention(seconds int64) (time.Duration, *apiError) {
+	if seconds < int64(minRetention.Seconds()) || seconds > int64(maxRetention.Seconds()) {
+		return 0, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("retention must be from %d to %d seconds, got %d", int64(minRetention.Seconds()), int64(maxRetention.Seconds()), seconds),
+		)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// jobRetention is the retention asked by the client, else the retention of the request key.
+// Zero leaves the default one to the worker.
+func jobRetention(ctx context.Context, requested time.Duration) time.Duration {
+	if requested > 0 {
+		return requested
+	}
+	if key := auth.FromContext(ctx); key != nil {
+		return time.Duration(key.Retention) * time.Second
+	}
+	return 0
+}
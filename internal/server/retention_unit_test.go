@@ -0,0 +1,46 @@
+package server
+
+import (
+	"comixifier/internal/auth"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadRetention_Unit(t *testing.T) {
+	for _, test := range []struct {
+		target    string
+		retention time.Duration
+		wantErr   bool
+	}{
+		{target: "/v2/transforms", retention: 0},
+		{target: "/v2/transforms?retention=3600", retention: time.Hour},
+		{target: "/v2/transforms?retention=2592000", retention: maxRetention},
+		{target: "/v2/transforms?retention=59", wantErr: true},
+		{target: "/v2/transforms?retention=2592001", wantErr: true},
+		{target: "/v2/transforms?retention=1h", wantErr: true},
+	} {
+		retention, apiErr := readRetention(httptest.NewRequest("POST", test.target, nil))
+		if (apiErr != nil) != test.wantErr || retention != test.retention {
+			t.Logf("%s got: %s, %v; expected: %s, error %t", test.target, retention, apiErr, test.retention, test.wantErr)
+			t.FailNow()
+		}
+	}
+}
+
+func TestJobRetention_Unit(t *testing.T) {
+	ctx := auth.WithKey(context.Background(), &auth.Key{KeyId: "key", Retention: 7200})
+	if retention := jobRetention(ctx, time.Hour); retention != time.Hour {
+		t.Logf("retention asked by the client got: %s; expected: %s", retention, time.Hour)
+		t.FailNow()
+	}
+	if retention := jobRetention(ctx, 0); retention != 2*time.Hour {
+		t.Logf("retention of the key got: %s; expected: %s", retention, 2*time.Hour)
+		t.FailNow()
+	}
+	if retention := jobRetention(context.Background(), 0); retention != 0 {
+		t.Logf("default retention got: %s; expected: 0", retention)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,480 @@
+package server
+
+import (
+	"comixifier/internal/auth"
+	"comixifier/internal/cache"
+	"comixifier/internal/gc"
+	"comixifier/internal/limit"
+	"comixifier/internal/openapi"
+	"comixifier/internal/queue"
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"comixifier/internal/usage"
+	"comixifier/internal/worker"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// presignedURLExpiry is how long redirected clients can download a result.
+	presignedURLExpiry = 15 * time.Minute
+	// resultMaxAge is how long caches may keep a downloaded result.
+	resultMaxAge = 10 * time.Minute
+)
+
+// Server serves the comixifier HTTP API.
+type Server struct {
+	transforms      *state.Storage
+	queue           *queue.Queue
+	pool            *worker.Pool
+	cache           *cache.Cache
+	images          *minio.Client
+	bucket          string
+	inputBuckets    []string
+	callbackEnabled bool
+	// keys authenticate clients, nil disables authentication.
+	keys *auth.Keys
+	// limiter enforces limits of keys and providers, nil disables limits.
+	limiter *limit.Limiter
+	ledger  *usage.Ledger
+	// collector removes garbage of the image storage, nil disables garbage collection.
+	collector *gc.Collector
+	// adminToken authenticates admin endpoints, they are disabled without it.
+	adminToken string
+	// closing is closed on shutdown to end event streams, which never become idle by themselves.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewServer(
+	transforms *state.Storage,
+	queue *queue.Queue,
+	pool *worker.Pool,
+	cache *cache.Cache,
+	images *minio.Client,
+	bucket string,
+	inputBuckets []string,
+	callbackEnabled bool,
+	keys *auth.Keys,
+	limiter *limit.Limiter,
+	ledger *usage.Ledger,
+	collector *gc.Collector,
+	adminToken string,
+) *Server {
+	return &Server{
+		transforms:      transforms,
+		queue:           queue,
+		pool:            pool,
+		cache:           cache,
+		images:          images,
+		bucket:          bucket,
+		inputBuckets:    inputBuckets,
+		callbackEnabled: callbackEnabled,
+		keys:            keys,
+		limiter:         limiter,
+		ledger:          ledger,
+		collector:       collector,
+		adminToken:      adminToken,
+		closing:         make(chan struct{}),
+	}
+}
+
+// CloseStreams ends event streams of clients, so the HTTP server can shut down.
+// Clients reconnect to another process of the server.
+func (s *Server) CloseStreams() {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("/comixifiers", s.handleComixifiers)
+	mux.HandleFunc("/download", s.handleDownload)
+	mux.HandleFunc("/progress", s.handleProgress)
+	mux.HandleFunc("/transform", s.handleTransform)
+	mux.HandleFunc("/transform/", s.handleCancel)
+	mux.HandleFunc("/transforms/", s.handleEvents)
+
+	mux.HandleFunc("/v2/comixifiers", s.handleComixifiers)
+	mux.HandleFunc("/v2/transforms", s.handleV2Transforms)
+	mux.HandleFunc("/v2/transforms/", s.handleV2Transform)
+	mux.HandleFunc("/v2/uploads", s.handleV2Uploads)
+	mux.HandleFunc("/v2/cache", s.handleV2Cache)
+	mux.HandleFunc("/v2/batches", s.handleV2Batches)
+	mux.HandleFunc("/v2/batches/", s.handleV2Batch)
+	mux.HandleFunc("/v2/comparisons", s.handleV2Comparisons)
+	mux.HandleFunc("/v2/comparisons/", s.handleV2Comparison)
+	mux.HandleFunc(usagePath, s.handleUsage)
+
+	if s.keys != nil && s.adminToken != "" {
+		mux.HandleFunc(adminKeysPath, s.handleAdminKeys)
+		mux.HandleFunc(adminKeysPath+"/", s.handleAdminKey)
+	}
+	if s.limiter != nil && s.adminToken != "" {
+		mux.HandleFunc(adminLimitsPath, s.handleAdminLimits)
+		mux.HandleFunc(adminLimitsPath+"/", s.handleAdminLimit)
+	}
+	if s.collector != nil && s.adminToken != "" {
+		mux.HandleFunc(adminGCPath, s.handleAdminGC)
+	}
+
+	return s.authenticate(validateRequests(mux))
+}
+
+// validateRequests rejects requests which don't match the OpenAPI document.
+func validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := openapi.Validate(r)
+		if err != nil {
+			writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest, err.Error()))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openapi.Spec())
+}
+
+func (s *Server) handleComixifiers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"comixifiers": registry.List(),
+	})
+}
+
+// transformRequest is a new transform asked by a client.
+type transformRequest struct {
+	comixifier  string
+	options     map[string]interface{}
+	callbackURL string
+	image       io.Reader
+	imageSize   int64
+	// input references an image in the image storage instead of the image in the request.
+	input *objectRef
+	// groupId is set for items of a batch or a comparison.
+	groupId string
+	// noFallback keeps the transform to the comixifier, as comparison items must be made by their comixifiers.
+	noFallback bool
+	// noCache runs the transform even if the result of the same input is cached.
+	noCache bool
+	// retention is how long the client asks to keep the result, zero if it doesn't ask.
+	retention time.Duration
+	// limitHeaders receive rate limit headers of the transform unless it's nil.
+	limitHeaders http.Header
+}
+
+func (s *Server) createTransform(ctx context.Context, req *transformRequest) (*state.Job, *apiError) {
+	provider, ok := registry.Get(req.comixifier)
+	if !ok {
+		return nil, newApiError(http.StatusBadRequest, CodeUnknownComixifier,
+			fmt.Sprintf("unknown comixifier: %q", req.comixifier),
+		)
+	}
+
+	options, err := provider.ParseOptions(req.options)
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidOptions, err.Error())
+	}
+
+	if req.callbackURL != "" {
+		if !s.callbackEnabled {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidCallbackURL, "callbacks are not configured")
+		}
+		parsedURL, err := url.Parse(req.callbackURL)
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidCallbackURL,
+				fmt.Sprintf("invalid callback url: %q", req.callbackURL),
+			)
+		}
+	}
+
+	transformId, err := uuid.NewUUID()
+	if err != nil {
+		log.Printf("transform: generate uuid: %s\n", err.Error())
+		return nil, errInternal()
+	}
+
+	job := &state.Job{
+		TransformId: transformId.String(),
+		Comixifier:  provider.Name,
+		Options:     options,
+		CallbackURL: req.callbackURL,
+		GroupId:     req.groupId,
+		NoFallback:  req.noFallback,
+		KeyId:       requestKeyId(ctx),
+		Retention:   jobRetention(ctx, req.retention),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	// Limits are checked before the input is stored, so limited requests cost no storage.
+	decision, apiErr := s.takeLimit(ctx, provider.Name, req.limitHeaders)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if req.input != nil {
+		apiErr = s.referenceInput(ctx, job, provider, req.input)
+	} else {
+		job.Input, job.InputInfo, apiErr = s.uploadInput(ctx, job.TransformId, provider, req)
+	}
+	if apiErr != nil {
+		s.refundLimit(decision)
+		return nil, apiErr
+	}
+
+	var cached *cache.Result
+	if !req.noCache {
+		cached = s.cachedResult(ctx, job)
+	}
+	if cached == nil {
+		job.InFlight = inFlightIdentity(job)
+	}
+
+	err = s.transforms.Create(ctx, job)
+	if err != nil {
+		log.Printf("transform: create transform in state storage: %s\n", err.Error())
+		s.abandonTransform(job, decision)
+		return nil, errInternal()
+	}
+
+	if cached != nil {
+		err = s.pool.FinishCached(ctx, job, cached)
+		if err != nil {
+			log.Printf("transform: finish transform with cached result: %s\n", err.Error())
+			return nil, errInternal()
+		}
+		return job, nil
+	}
+
+	if job.InFlight != "" {
+		leaderId, err := s.transforms.Lead(ctx, job.InFlight, job.TransformId)
+		if err != nil {
+			// The transform runs on its own when it can't be merged.
+			log.Printf("transform: merge with transforms in flight: %s\n", err.Error())
+		}
+		if leaderId != "" {
+			return job, nil
+		}
+	}
+
+	err = s.queue.Push(ctx, transformId.String())
+	if err != nil {
+		log.Printf("transform: push transform to queue: %s\n", err.Error())
+		s.abandonTransform(job, decision)
+		return nil, errInternal()
+	}
+
+	return job, nil
+}
+
+// abandonTransform undoes what was done for the transform which failed to start:
+// the transform is given back to the limits and its stored input is removed.
+func (s *Server) abandonTransform(job *state.Job, decision *limit.Decision) {
+	s.refundLimit(decision)
+	if job.Input != "" && !job.KeepInput {
+		s.removeObject(job.Input)
+	}
+}
+
+// inFlightIdentity tells the transform apart from others in flight by what gives its result:
+// the input, the comixifier with its options and whether it may fall back to other comixifiers.
+func inFlightIdentity(job *state.Job) string {
+	if job.InputInfo == nil || job.InputInfo.SHA256 == "" {
+		return ""
+	}
+	identity := "inflight:" + cache.Identity(job.InputInfo.SHA256, job.Comixifier, job.Options)
+	if job.NoFallback {
+		identity += ":no-fallback"
+	}
+	return identity
+}
+
+func (s *Server) transformState(ctx context.Context, transformId string) (*state.Event, *apiError) {
+	event, err := s.transforms.Snapshot(ctx, transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, errTransformNotFound()
+	}
+	if err != nil {
+		log.Printf("progress: get transform state: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	return event, nil
+}
+
+// transformJob returns the job of the transform or nil if it has already expired.
+func (s *Server) transformJob(ctx context.Context, transformId string) (*state.Job, *apiError) {
+	job, err := s.transforms.Job(ctx, transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("transform: get job: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	return job, nil
+}
+
+func (s *Server) cancelTransform(ctx context.Context, transformId string) *apiError {
+	status, err := s.transforms.Status(ctx, transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		return errTransformNotFound()
+	}
+	if err != nil {
+		log.Printf("cancel: get status from state storage: %s\n", err.Error())
+		return errInternal()
+	}
+
+	cancelled := false
+	if !status.IsFinal() {
+		cancelled, err = s.transforms.Cancel(ctx, transformId)
+		if err != nil {
+			log.Printf("cancel: save status to state storage: %s\n", err.Error())
+			return errInternal()
+		}
+	}
+	if !cancelled {
+		return newApiError(http.StatusConflict, CodeTransformCompleted, "transform is already completed")
+	}
+
+	err = s.pool.Cancel(ctx, transformId)
+	if err != nil {
+		log.Printf("cancel: stop running transform: %s\n", err.Error())
+	}
+	return nil
+}
+
+// writeResult sends the result image of the finished transform in the format asked by the client.
+// With the redirect parameter the client is sent to a presigned image storage URL instead.
+func (s *Server) writeResult(w http.ResponseWriter, r *http.Request, transformId string) {
+	format, redirect, apiErr := readResultParams(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	status, err := s.transforms.Status(r.Context(), transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		writeError(w, errTransformNotFound())
+		return
+	}
+	if err != nil {
+		log.Printf("download: get status from state storage: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	if status != state.StatusFinish {
+		writeError(w, newApiError(http.StatusConflict, CodeTransformNotFinished,
+			fmt.Sprintf("transform is not finished, status: %s", status),
+		))
+		return
+	}
+
+	imgFilePath, err := s.transforms.File(r.Context(), transformId)
+	if errors.Is(err, state.ErrNotFound) {
+		writeError(w, errTransformNotFound())
+		return
+	}
+	if err != nil {
+		log.Printf("download: get img file path from state storage: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	imgFilePath, apiErr = s.renditionKey(r.Context(), transformId, imgFilePath, r.URL.Query().Get("rendition"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	s.writeObject(w, r, imgFilePath, format, redirect)
+}
+
+// resultCacheControl lets any cache keep results unless they belong to API keys,
+// then only the client's own cache may keep them.
+func (s *Server) resultCacheControl() string {
+	if s.keys != nil {
+		return fmt.Sprintf("private, max-age=%d", int(resultMaxAge.Seconds()))
+	}
+	return fmt.Sprintf("public, max-age=%d", int(resultMaxAge.Seconds()))
+}
+
+// writeObject sends the image storage object in the format or redirects to its presigned URL.
+// Range and conditional requests are served from the object.
+func (s *Server) writeObject(w http.ResponseWriter, r *http.Request, imgFilePath string, format *resultFormat, redirect bool) {
+	imgFilePath, apiErr := s.resultVariant(r.Context(), imgFilePath, format)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	if !r.URL.Query().Has("format") {
+		w.Header().Add("Vary", "Accept")
+	}
+	if s.keys != nil {
+		w.Header().Add("Vary", "Authorization, X-API-Key")
+	}
+
+	if redirect {
+		presignedURL, err := s.images.PresignedGetObject(r.Context(), s.bucket, imgFilePath, presignedURLExpiry, nil)
+		if err != nil {
+			log.Printf("download: presign url: %s\n", err.Error())
+			writeError(w, errInternal())
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, presignedURL.String(), http.StatusFound)
+		return
+	}
+
+	imgFile, err := s.images.GetObject(r.Context(), s.bucket, imgFilePath, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("download: get file from storage: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	defer imgFile.Close()
+
+	imgInfo, err := imgFile.Stat()
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		writeError(w, errTransformNotFound())
+		return
+	}
+	if err != nil {
+		log.Printf("download: stat file in storage: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	// Stored results never change, so caches may keep them.
+	w.Header().Set("Content-Type", imgInfo.ContentType)
+	w.Header().Set("ETag", `"`+imgInfo.ETag+`"`)
+	w.Header().Set("Cache-Control", s.resultCacheControl())
+	http.ServeContent(w, r, path.Base(imgFilePath), imgInfo.LastModified, imgFile)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"comixifier/internal"
+	"comixifier/internal/imaging"
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Errors_Unit(t *testing.T) {
+	handler := NewServer(nil, nil, nil, nil, nil, "test", nil, false, nil, nil, nil, nil, "").Handler()
+
+	type testCase struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantCode   string
+	}
+	tests := []testCase{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			target:     "/v2/transforms",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCode:   CodeMethodNotAllowed,
+		},
+		{
+			name:       "unknown comixifier",
+			method:     http.MethodPost,
+			target:     "/v2/transforms?comixifier=unknown",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeUnknownComixifier,
+		},
+		{
+			name:       "unknown subresource",
+			method:     http.MethodGet,
+			target:     "/v2/transforms/some_id/unknown",
+			wantStatus: http.StatusNotFound,
+			wantCode:   CodeNotFound,
+		},
+		{
+			name:       "result with invalid redirect",
+			method:     http.MethodGet,
+			target:     "/v2/transforms/6ba7b810-9dad-11d1-80b4-00c04fd430c8/result?redirect=maybe",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidRequest,
+		},
+		{
+			name:       "progress without transformId",
+			method:     http.MethodPost,
+			target:     "/progress",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidRequest,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, strings.NewReader("{}"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.wantStatus {
+				t.Logf("status got: %d; expected: %d", rec.Code, test.wantStatus)
+				t.FailNow()
+			}
+
+			respBody := struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}{}
+			err := json.Unmarshal(rec.Body.Bytes(), &respBody)
+			if err != nil {
+				t.Logf("unmarshal resp body: %s", err.Error())
+				t.FailNow()
+			}
+			if respBody.Error.Code != test.wantCode {
+				t.Logf("error code got: %s; expected: %s", respBody.Error.Code, test.wantCode)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestInFlightIdentity_Unit(t *testing.T) {
+	newJob := func(comixifier string, style string, noFallback bool) *state.Job {
+		return &state.Job{
+			TransformId: "t",
+			Comixifier:  comixifier,
+			Options:     internal.Options{"style": style},
+			InputInfo:   &imaging.Info{SHA256: "abc"},
+			NoFallback:  noFallback,
+		}
+	}
+
+	identity := inFlightIdentity(newJob("a", "1", false))
+	other := newJob("a", "1", false)
+	other.TransformId = "u"
+	if inFlightIdentity(other) != identity {
+		t.Logf("identities of identical transforms differ: %q, %q", inFlightIdentity(other), identity)
+		t.FailNow()
+	}
+	for _, job := range []*state.Job{newJob("b", "1", false), newJob("a", "2", false), newJob("a", "1", true)} {
+		if inFlightIdentity(job) == identity {
+			t.Logf("identity of %s %v no fallback %t got: %q; expected different", job.Comixifier, job.Options, job.NoFallback, identity)
+			t.FailNow()
+		}
+	}
+
+	if got := inFlightIdentity(&state.Job{Comixifier: "a"}); got != "" {
+		t.Logf("identity without input hash got: %q; expected empty", got)
+		t.FailNow()
+	}
+}
+
+func TestTransformResource_Interruptions_Unit(t *testing.T) {
+	event := &state.Event{TransformId: "t", Status: state.StatusWait, Stage: state.StageInterrupted, Interruptions: 2}
+	resource := transformResource(event, nil, nil, nil)
+	if resource["stage"] != state.StageInterrupted || resource["interruptions"] != int64(2) {
+		t.Logf("resource got: stage %v, interruptions %v; expected: stage %s, interruptions 2", resource["stage"], resource["interruptions"], state.StageInterrupted)
+		t.FailNow()
+	}
+
+	event = &state.Event{TransformId: "t", Status: state.StatusWait, Stage: state.StageQueued}
+	if _, ok := transformResource(event, nil, nil, nil)["interruptions"]; ok {
+		t.Logf("resource of transform which wasn't interrupted got: interruptions; expected none")
+		t.FailNow()
+	}
+}
+
+func TestServer_ReferenceInput_Generated_Unit(t *testing.T) {
+	s := NewServer(nil, nil, nil, nil, nil, "test", []string{"photos"}, false, nil, nil, nil, nil, "")
+	for _, test := range []struct {
+		ref  *objectRef
+		code string
+	}{
+		{ref: &objectRef{Key: "input_6f1c2a3e-0b4d-11ef-9a6b-0242ac120002"}, code: CodeInputNotAllowed},
+		{ref: &objectRef{Bucket: "test", Key: "img_6f1c2a3e-0b4d-11ef-9a6b-0242ac120002_1714000000_1.png"}, code: CodeInputNotAllowed},
+		{ref: &objectRef{Key: "comparison_6f1c2a3e-0b4d-11ef-9a6b-0242ac120002_thumbnail.png"}, code: CodeInputNotAllowed},
+		{ref: &objectRef{Bucket: "other", Key: "img_1.png"}, code: CodeInputBucketNotAllowed},
+	} {
+		apiErr := s.referenceInput(context.Background(), &state.Job{}, &registry.Provider{}, test.ref)
+		if apiErr == nil || apiErr.Code != test.code {
+			t.Logf("reference of %s/%s got: %v; expected: %s", test.ref.Bucket, test.ref.Key, apiErr, test.code)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// uploadPrefix starts keys of images uploaded by clients with presigned URLs.
+	uploadPrefix = "upload_"
+	// uploadURLExpiry is how long a client can use a presigned upload URL.
+	uploadURLExpiry = 15 * time.Minute
+	// uploadOwnerTTL is how long the owner of an upload is kept, unused uploads are collected after a day.
+	uploadOwnerTTL = 24 * time.Hour
+	// maxUploadSize is the largest upload for comixifiers which don't limit the size of their inputs.
+	maxUploadSize = 50 << 20
+)
+
+// generatedPrefixes start keys of objects the server stores in its bucket for transforms: their inputs,
+// results and comparison composites. Clients can't reference them, as they may belong to other API keys.
+var generatedPrefixes = []string{"input_", "img_", "comparison_"}
+
+// objectRef is an image in the image storage referenced by a client.
+type objectRef struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// copy gives the transform its own copy of the image and leaves the image to the caller.
+	copy bool
+	// staged is set for images the server stored itself for the request, not referenced by the client.
+	staged bool
+}
+
+// referenceRequest is a JSON body of a transform of an image which is already in the image storage.
+type referenceRequest struct {
+	Comixifier  string                 `json:"comixifier"`
+	Options     map[string]interface{} `json:"options"`
+	CallbackURL string                 `json:"callbackUrl"`
+	Input       *objectRef             `json:"input"`
+}
+
+// handleV2Uploads serves POST /v2/uploads. It issues a presigned POST policy the client uploads an image with,
+// then the key of the image is passed as the input of POST /v2/transforms. The policy limits the upload to an image
+// no larger than the inputs of any comixifier.
+func (s *Server) handleV2Uploads(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	uploadId, err := uuid.NewRandom()
+	if err != nil {
+		log.Printf("upload: generate uuid: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	key := uploadPrefix + uploadId.String()
+
+	if keyId := requestKeyId(r.Context()); keyId != "" {
+		err = s.transforms.OwnUpload(r.Context(), key, keyId, uploadOwnerTTL)
+		if err != nil {
+			log.Printf("upload: save upload owner to state storage: %s\n", err.Error())
+			writeError(w, errInternal())
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(uploadURLExpiry)
+	maxSize := uploadSizeLimit(registry.List())
+	policy, err := uploadPolicy(s.bucket, key, expiresAt, maxSize)
+	if err != nil {
+		log.Printf("upload: make post policy: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	uploadURL, formData, err := s.images.PresignedPostPolicy(r.Context(), policy)
+	if err != nil {
+		log.Printf("upload: presign post policy: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"bucket":    s.bucket,
+		"key":       key,
+		"method":    http.MethodPost,
+		"uploadUrl": uploadURL.String(),
+		"formData":  formData,
+		"maxSize":   maxSize,
+		"expiresAt": expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// uploadPolicy lets the client upload an image of at most maxSize bytes to the key until the time.
+func uploadPolicy(bucket string, key string, expiresAt time.Time, maxSize int64) (*minio.PostPolicy, error) {
+	policy := minio.NewPostPolicy()
+	for _, err := range []error{
+		policy.SetBucket(bucket),
+		policy.SetKey(key),
+		policy.SetExpires(expiresAt.UTC()),
+		policy.SetContentLengthRange(1, maxSize),
+		policy.SetContentTypeStartsWith("image/"),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// uploadSizeLimit is the largest input of the providers, maxUploadSize stands for providers without a limit.
+func uploadSizeLimit(providers []*registry.Provider) int64 {
+	var limit int64
+	for _, provider := range providers {
+		size := provider.MaxInputSize
+		if size == 0 {
+			size = maxUploadSize
+		}
+		if size > limit {
+			limit = size
+		}
+	}
+	if limit == 0 {
+		return maxUploadSize
+	}
+	return limit
+}
+
+// readReferenceRequest fills req with the JSON body of the request.
+func readReferenceRequest(r *http.Request, req *transformRequest) *apiError {
+	rawReqBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return newApiError(http.StatusBadRequest, CodeInvalidRequest, "read request body: "+err.Error())
+	}
+
+	reqBody := &referenceRequest{}
+	err = json.Unmarshal(rawReqBody, reqBody)
+	if err != nil {
+		return newApiError(http.StatusBadRequest, CodeInvalidRequest, "request body is not valid json: "+err.Error())
+	}
+	if reqBody.Input == nil || reqBody.Input.Key == "" {
+		return newApiError(http.StatusBadRequest, CodeInvalidRequest, "input.key is required")
+	}
+
+	if reqBody.Comixifier != "" {
+		req.comixifier = reqBody.Comixifier
+	}
+	if reqBody.CallbackURL != "" {
+		req.callbackURL = reqBody.CallbackURL
+	}
+	req.options = reqBody.Options
+	req.input = reqBody.Input
+	return nil
+}
+
+// referenceInput checks the image referenced by the client and sets it as the input of the job.
+// Images uploaded with presigned URLs and images referenced with copy are copied for the transform
+// and the copy is removed after it, other images are left as they are.
+func (s *Server) referenceInput(ctx context.Context, job *state.Job, provider *registry.Provider, ref *objectRef) *apiError {
+	bucket := ref.Bucket
+	if bucket == "" {
+		bucket = s.bucket
+	}
+	if !s.inputBucketAllowed(bucket) {
+		return newApiError(http.StatusForbidden, CodeInputBucketNotAllowed,
+			fmt.Sprintf("bucket %q is not allowed for input images", bucket),
+		)
+	}
+	upload := bucket == s.bucket && strings.HasPrefix(ref.Key, uploadPrefix)
+	if bucket == s.bucket && !ref.staged && isGeneratedObject(ref.Key) {
+		return newApiError(http.StatusForbidden, CodeInputNotAllowed,
+			fmt.Sprintf("input image %s is stored by the server for transforms and can't be referenced", ref.Key),
+		)
+	}
+	if upload {
+		apiErr := s.authorizeUpload(ctx, bucket, ref.Key)
+		if apiErr != nil {
+			return apiErr
+		}
+	}
+
+	object, err := s.images.GetObject(ctx, bucket, ref.Key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("transform: get input image from image storage: %s\n", err.Error())
+		return errInternal()
+	}
+	defer object.Close()
+
+	objectInfo, err := object.Stat()
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" || minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+		return newApiError(http.StatusBadRequest, CodeInputNotFound,
+			fmt.Sprintf("input image %s/%s not found", bucket, ref.Key),
+		)
+	}
+	if err != nil {
+		log.Printf("transform: stat input image in image storage: %s\n", err.Error())
+		return errInternal()
+	}
+	if objectInfo.Size == 0 {
+		return errEmptyInput()
+	}
+	if provider.MaxInputSize > 0 && objectInfo.Size > provider.MaxInputSize {
+		return errInputTooLarge(provider)
+	}
+
+	info, image, apiErr := checkImage(provider, object)
+	if apiErr != nil {
+		return apiErr
+	}
+	info.Size = objectInfo.Size
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, image)
+	if err != nil {
+		log.Printf("transform: read input image from image storage: %s\n", err.Error())
+		return errInternal()
+	}
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	job.InputInfo = info
+
+	if !upload && !ref.copy {
+		job.Input = ref.Key
+		job.InputBucket = bucket
+		job.KeepInput = true
+		return nil
+	}
+
+	input := "input_" + job.TransformId
+	_, err = s.images.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: input},
+		minio.CopySrcOptions{Bucket: bucket, Object: ref.Key},
+	)
+	if err != nil {
+		log.Printf("transform: copy input image in image storage: %s\n", err.Error())
+		return errInternal()
+	}
+	// An upload is taken by the transform, so it can't be referenced twice.
+	if upload && !ref.copy {
+		s.removeObject(ref.Key)
+	}
+
+	job.Input = input
+	return nil
+}
+
+// authorizeUpload hides uploads issued to other API keys as if they didn't exist.
+func (s *Server) authorizeUpload(ctx context.Context, bucket string, key string) *apiError {
+	keyId := requestKeyId(ctx)
+	if keyId == "" {
+		return nil
+	}
+
+	owner, err := s.transforms.UploadOwner(ctx, key)
+	if err != nil && !errors.Is(err, state.ErrNotFound) {
+		log.Printf("transform: get upload owner from state storage: %s\n", err.Error())
+		return errInternal()
+	}
+	if owner != keyId {
+		return newApiError(http.StatusBadRequest, CodeInputNotFound,
+			fmt.Sprintf("input image %s/%s not found", bucket, key),
+		)
+	}
+	return nil
+}
+
+func isGeneratedObject(key string) bool {
+	for _, prefix := range generatedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) inputBucketAllowed(bucket string) bool {
+	if bucket == s.bucket {
+		return true
+	}
+	for _, allowed := range s.inputBuckets {
+		if allowed == bucket {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"comixifier/internal/registry"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadReferenceRequest_Unit(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v2/transforms", strings.NewReader(
+		`{"comixifier": "cutout", "options": {"cartoonType": 3}, "input": {"bucket": "photos", "key": "a.jpeg"}}`,
+	))
+	req := &transformRequest{comixifier: "VanceAI"}
+	apiErr := readReferenceRequest(r, req)
+	if apiErr != nil {
+		t.Logf("error got: %s; expected: <nil>", apiErr.Error())
+		t.FailNow()
+	}
+	if req.comixifier != "cutout" || req.input == nil || req.input.Bucket != "photos" || req.input.Key != "a.jpeg" {
+		t.Logf("request got: %+v; expected: cutout photos/a.jpeg", req)
+		t.FailNow()
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/v2/transforms", strings.NewReader(`{"comixifier": "cutout"}`))
+	apiErr = readReferenceRequest(r, &transformRequest{})
+	if apiErr == nil || apiErr.Code != CodeInvalidRequest {
+		t.Logf("error got: %v; expected: %s", apiErr, CodeInvalidRequest)
+		t.FailNow()
+	}
+}
+
+func TestServer_InputBucketAllowed_Unit(t *testing.T) {
+	s := NewServer(nil, nil, nil, nil, nil, "test", []string{"photos"}, false, nil, nil, nil, nil, "")
+
+	for bucket, want := range map[string]bool{"test": true, "photos": true, "private": false} {
+		if got := s.inputBucketAllowed(bucket); got != want {
+			t.Logf("%s: allowed got: %t; expected: %t", bucket, got, want)
+			t.FailNow()
+		}
+	}
+}
+
+func TestUploadPolicy_Unit(t *testing.T) {
+	maxSize := uploadSizeLimit([]*registry.Provider{{MaxInputSize: 10 << 20}, {MaxInputSize: 20 << 20}})
+	if maxSize != 20<<20 {
+		t.Logf("upload size limit got: %d; expected: %d", maxSize, 20<<20)
+		t.FailNow()
+	}
+	if unlimited := uploadSizeLimit([]*registry.Provider{{MaxInputSize: 10 << 20}, {}}); unlimited != maxUploadSize {
+		t.Logf("upload size limit got: %d; expected: %d", unlimited, maxUploadSize)
+		t.FailNow()
+	}
+
+	policy, err := uploadPolicy("test", "upload_a", time.Now().Add(uploadURLExpiry), maxSize)
+	if err != nil {
+		t.Logf("upload policy got: %s", err.Error())
+		t.FailNow()
+	}
+	for _, condition := range []string{
+		`["eq","$key","upload_a"]`,
+		`["content-length-range", 1, 20971520]`,
+		`["starts-with","$Content-Type","image/"]`,
+	} {
+		if !strings.Contains(policy.String(), condition) {
+			t.Logf("policy got: %s; expected condition: %s", policy.String(), condition)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"comixifier/internal/auth"
+	"comixifier/internal/usage"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	usagePath = "/usage"
+	// defaultUsagePeriod is reported unless the request sets from.
+	defaultUsagePeriod = 7 * 24 * time.Hour
+	// maxKeyUsagePeriod is the longest period clients can report at once, admins report any period.
+	maxKeyUsagePeriod = 93 * 24 * time.Hour
+	// maxUsageRecords is how many records are listed at once, longer periods are to be summarized.
+	maxUsageRecords = 10000
+)
+
+// usageQuery is a usage report asked by a client.
+type usageQuery struct {
+	from    time.Time
+	to      time.Time
+	keyId   string
+	groupBy []string
+	csv     bool
+}
+
+// handleUsage serves GET /usage. It lists ledger records of attempts of completed transforms in the period,
+// or summarizes them with groupBy. Clients see their own usage, admins see usage of every key.
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if s.ledger == nil {
+		writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "usage is not recorded"))
+		return
+	}
+
+	query, apiErr := readUsageQuery(r, time.Now())
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	if len(query.groupBy) == 0 {
+		records, err := s.ledger.Records(r.Context(), query.from, query.to, query.keyId, maxUsageRecords)
+		if errors.Is(err, usage.ErrTooManyRecords) {
+			writeError(w, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("period has more than %d records, ask for a shorter period or groupBy", maxUsageRecords),
+			))
+			return
+		}
+		if err != nil {
+			log.Printf("usage: read usage ledger: %s\n", err.Error())
+			writeError(w, errInternal())
+			return
+		}
+
+		if query.csv {
+			writeUsageCSV(w, recordsCSV(records))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"from":    query.from,
+			"to":      query.to,
+			"records": records,
+		})
+		return
+	}
+
+	groups, err := s.ledger.Summarize(r.Context(), query.from, query.to, query.keyId, query.groupBy)
+	if err != nil {
+		log.Printf("usage: summarize usage ledger: %s\n", err.Error())
+		writeError(w, errInternal())
+		return
+	}
+	if query.csv {
+		writeUsageCSV(w, groupsCSV(groups, query.groupBy))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"from":    query.from,
+		"to":      query.to,
+		"groupBy": query.groupBy,
+		"groups":  groups,
+	})
+}
+
+// readUsageQuery reads the from, to, keyId, groupBy and format query parameters. CSV is also
+// negotiated by Accept. Dates are RFC 3339 times or days, which start at UTC midnight.
+// Clients get their own usage of at most maxKeyUsagePeriod.
+func readUsageQuery(r *http.Request, now time.Time) (*usageQuery, *apiError) {
+	params := r.URL.Query()
+	query := &usageQuery{
+		from:    now.Add(-defaultUsagePeriod).UTC(),
+		to:      now.UTC(),
+		keyId:   params.Get("keyId"),
+		groupBy: splitList(params.Get("groupBy")),
+	}
+
+	var apiErr *apiError
+	if params.Has("from") {
+		query.from, apiErr = readUsageTime(params, "from")
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	if params.Has("to") {
+		query.to, apiErr = readUsageTime(params, "to")
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	if !query.from.Before(query.to) {
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidRequest, "from must be before to")
+	}
+	if key := auth.FromContext(r.Context()); key != nil {
+		query.keyId = key.KeyId
+		if query.to.Sub(query.from) > maxKeyUsagePeriod {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("period must not be longer than %d days", int(maxKeyUsagePeriod.Hours()/24)),
+			)
+		}
+	}
+
+	for _, field := range query.groupBy {
+		if !usage.IsGroupBy(field) {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("unsupported groupBy: %q, supported: %s, %s, %s", field, usage.GroupByKey, usage.GroupByProvider, usage.GroupByDay),
+			)
+		}
+	}
+
+	switch params.Get("format") {
+	case "csv":
+		query.csv = true
+	case "json":
+	case "":
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Accept"))
+		query.csv = mediaType == "text/csv"
+	default:
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("unsupported format: %q, supported: json, csv", params.Get("format")),
+		)
+	}
+	return query, nil
+}
+
+func readUsageTime(params url.Values, name string) (time.Time, *apiError) {
+	raw := params.Get(name)
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", raw)
+	}
+	if err != nil {
+		return time.Time{}, newApiError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("%s must be an RFC 3339 time or a date like 2006-01-02, got %q", name, raw),
+		)
+	}
+	return t.UTC(), nil
+}
+
+func recordsCSV(records []*usage.Record) [][]string {
+	rows := [][]string{{
+		"time", "transformId", "keyId", "comixifier", "provider", "options", "attempt", "fallback", "reason", "status", "reused",
+		"inputSize", "outputSize", "durationMs", "credits",
+	}}
+	for _, record := range records {
+		options := url.Values{}
+		for name, value := range record.Options {
+			options.Set(name, value)
+		}
+		rows = append(rows, []string{
+			record.Time.UTC().Format(time.RFC3339),
+			record.TransformId,
+			record.KeyId,
+			record.Comixifier,
+			record.Provider,
+			options.Encode(),
+			strconv.Itoa(record.Attempt),
+			strconv.FormatBool(record.Fallback),
+			record.Reason,
+			string(record.Status),
+			strconv.FormatBool(record.Reused),
+			strconv.FormatInt(record.InputSize, 10),
+			strconv.FormatInt(record.OutputSize, 10),
+			strconv.FormatInt(record.DurationMs, 10),
+			strconv.FormatFloat(record.Credits, 'f', -1, 64),
+		})
+	}
+	return rows
+}
+
+// groupsCSV has a column of every group field followed by totals.
+func groupsCSV(groups []*usage.Group, groupBy []string) [][]string {
+	header := append([]string{}, groupBy...)
+	header = append(header, "transforms", "finished", "failed", "reused", "fallbacks", "inputSize", "outputSize", "durationMs", "credits")
+
+	rows := [][]string{header}
+	for _, group := range groups {
+		row := make([]string, 0, len(header))
+		for _, field := range groupBy {
+			switch field {
+			case usage.GroupByKey:
+				row = append(row, group.KeyId)
+			case usage.GroupByProvider:
+				row = append(row, group.Provider)
+			case usage.GroupByDay:
+				row = append(row, group.Day)
+			}
+		}
+		row = append(row,
+			strconv.FormatInt(group.Transforms, 10),
+			strconv.FormatInt(group.Finished, 10),
+			strconv.FormatInt(group.Failed, 10),
+			strconv.FormatInt(group.Reused, 10),
+			strconv.FormatInt(group.Fallbacks, 10),
+			strconv.FormatInt(group.InputSize, 10),
+			strconv.FormatInt(group.OutputSize, 10),
+			strconv.FormatInt(group.DurationMs, 10),
+			strconv.FormatFloat(group.Credits, 'f', -1, 64),
+		)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func writeUsageCSV(w http.ResponseWriter, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	err := writer.WriteAll(rows)
+	if err != nil {
+		log.Printf("usage: write csv: %s\n", err.Error())
+	}
+}
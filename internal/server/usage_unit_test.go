@@ -0,0 +1,87 @@
+package server
+
+import (
+	"comixifier/internal/auth"
+	"comixifier/internal/usage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadUsageQuery_Unit(t *testing.T) {
+	now := time.Date(2024, time.May, 10, 12, 0, 0, 0, time.UTC)
+
+	r := httptest.NewRequest(http.MethodGet, "/usage?from=2024-05-01&to=2024-05-08T00:00:00Z&groupBy=key,day", nil)
+	r.Header.Set("Accept", "text/csv")
+	query, apiErr := readUsageQuery(r, now)
+	if apiErr != nil {
+		t.Logf("read query: %s", apiErr.Error())
+		t.FailNow()
+	}
+	if !query.from.Equal(time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)) || !query.to.Equal(time.Date(2024, time.May, 8, 0, 0, 0, 0, time.UTC)) ||
+		!reflect.DeepEqual(query.groupBy, []string{"key", "day"}) || !query.csv {
+		t.Logf("query got: %+v", query)
+		t.FailNow()
+	}
+
+	query, _ = readUsageQuery(httptest.NewRequest(http.MethodGet, "/usage", nil), now)
+	if !query.to.Equal(now) || !query.from.Equal(now.Add(-defaultUsagePeriod)) || query.csv {
+		t.Logf("default query got: %+v", query)
+		t.FailNow()
+	}
+
+	for _, target := range []string{
+		"/usage?from=yesterday",
+		"/usage?from=2024-05-08&to=2024-05-01",
+		"/usage?groupBy=week",
+		"/usage?format=xml",
+	} {
+		_, apiErr = readUsageQuery(httptest.NewRequest(http.MethodGet, target, nil), now)
+		if apiErr == nil || apiErr.Code != CodeInvalidRequest {
+			t.Logf("%s: error got: %v; expected: %s", target, apiErr, CodeInvalidRequest)
+			t.FailNow()
+		}
+	}
+}
+
+func TestReadUsageQuery_Key_Unit(t *testing.T) {
+	now := time.Date(2024, time.May, 10, 12, 0, 0, 0, time.UTC)
+	key := &auth.Key{KeyId: "k"}
+
+	r := httptest.NewRequest(http.MethodGet, "/usage?from=2024-04-01&keyId=other", nil)
+	query, apiErr := readUsageQuery(r.WithContext(auth.WithKey(r.Context(), key)), now)
+	if apiErr != nil || query.keyId != "k" {
+		t.Logf("query of key got: %+v, %v; expected usage of key k", query, apiErr)
+		t.FailNow()
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/usage?from=2023-05-01", nil)
+	_, apiErr = readUsageQuery(r.WithContext(auth.WithKey(r.Context(), key)), now)
+	if apiErr == nil || apiErr.Code != CodeInvalidRequest {
+		t.Logf("query of a year of key got: %v; expected: %s", apiErr, CodeInvalidRequest)
+		t.FailNow()
+	}
+
+	_, apiErr = readUsageQuery(httptest.NewRequest(http.MethodGet, "/usage?from=2023-05-01", nil), now)
+	if apiErr != nil {
+		t.Logf("query of a year by admin got: %s; expected no error", apiErr.Error())
+		t.FailNow()
+	}
+}
+
+func TestGroupsCSV_Unit(t *testing.T) {
+	rows := groupsCSV([]*usage.Group{
+		{Provider: "cutout", Day: "2024-05-01", Transforms: 2, Finished: 1, Failed: 1, Credits: 1.5},
+	}, []string{"day", "provider"})
+
+	expected := [][]string{
+		{"day", "provider", "transforms", "finished", "failed", "reused", "fallbacks", "inputSize", "outputSize", "durationMs", "credits"},
+		{"2024-05-01", "cutout", "2", "1", "1", "0", "0", "0", "0", "0", "1.5"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Logf("rows got: %v; expected: %v", rows, expected)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"comixifier/internal/state"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const v2TransformsPath = "/v2/transforms/"
+
+// handleV2Transforms serves POST /v2/transforms. The request body is the image or a multipart form
+// with image, options and comixifier parts. Unless the form has it, the comixifier is chosen
+// by the comixifier query parameter or the Comixifier-Name header.
+func (s *Server) handleV2Transforms(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	noCache, apiErr := readNoCache(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	retention, apiErr := readRetention(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	req := &transformRequest{
+		comixifier:   firstNonEmpty(r.URL.Query().Get("comixifier"), r.Header.Get("Comixifier-Name")),
+		callbackURL:  firstNonEmpty(r.URL.Query().Get("callbackUrl"), r.Header.Get("Callback-URL")),
+		noCache:      noCache,
+		retention:    retention,
+		limitHeaders: w.Header(),
+	}
+	cleanup, apiErr := readImageRequest(r, req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	defer cleanup()
+
+	job, apiErr := s.createTransform(r.Context(), req)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	// A transform with a cached result is finished already.
+	resource, apiErr := s.currentTransformResource(r.Context(), job.TransformId, job)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Location", v2TransformsPath+job.TransformId)
+	writeJSON(w, http.StatusAccepted, resource)
+}
+
+// handleV2Transform serves /v2/transforms/{id} and its subresources.
+func (s *Server) handleV2Transform(w http.ResponseWriter, r *http.Request) {
+	transformId, subresource := splitTransformPath(strings.TrimPrefix(r.URL.Path, v2TransformsPath))
+	if transformId == "" {
+		writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "not found"))
+		return
+	}
+	apiErr := s.authorizeTransform(r.Context(), transformId)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	switch subresource {
+	case "":
+		if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			apiErr := s.cancelTransform(r.Context(), transformId)
+			if apiErr != nil {
+				writeError(w, apiErr)
+				return
+			}
+		}
+
+		resource, apiErr := s.currentTransformResource(r.Context(), transformId, nil)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		writeJSON(w, http.StatusOK, resource)
+	case "result":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		s.writeResult(w, r, transformId)
+	case "events":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		s.streamEvents(w, r, transformId)
+	default:
+		writeError(w, newApiError(http.StatusNotFound, CodeNotFound, "not found"))
+	}
+}
+
+// currentTransformResource returns the representation of the transform in its current state.
+// The job is read from the state storage unless it's given.
+func (s *Server) currentTransformResource(ctx context.Context, transformId string, job *state.Job) (map[string]interface{}, *apiError) {
+	event, apiErr := s.transformState(ctx, transformId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if job == nil {
+		job, apiErr = s.transformJob(ctx, transformId)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	var renditions []*state.Rendition
+	if event.Status == state.StatusFinish {
+		renditions, apiErr = s.transformRenditions(ctx, transformId)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	attempts, apiErr := s.transformAttempts(ctx, transformId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return transformResource(event, job, renditions, attempts), nil
+}
+
+// transformResource is a representation of the transform in the v2 API.
+func transformResource(
+	event *state.Event,
+	job *state.Job,
+	renditions []*state.Rendition,
+	attempts []*state.Attempt,
+) map[string]interface{} {
+	links := map[string]string{
+		"self":   v2TransformsPath + event.TransformId,
+		"events": v2TransformsPath + event.TransformId + "/events",
+	}
+	if event.Status == state.StatusFinish {
+		links["result"] = v2TransformsPath + event.TransformId + "/result"
+	}
+
+	resource := map[string]interface{}{
+		"transformId": event.TransformId,
+		"status":      event.Status,
+		"stage":       event.Stage,
+		"links":       links,
+	}
+	if event.Error != "" {
+		resource["error"] = event.Error
+	}
+	if event.Interruptions > 0 {
+		resource["interruptions"] = event.Interruptions
+	}
+	if len(renditions) > 0 {
+		resource["renditions"] = renditionResources(event.TransformId, renditions)
+	}
+	if len(attempts) > 0 {
+		resource["attempts"] = attempts
+		if last := attempts[len(attempts)-1]; event.Status == state.StatusFinish && last.Error == "" {
+			resource["producedBy"] = last.Comixifier
+		}
+	}
+	if job != nil {
+		resource["comixifier"] = job.Comixifier
+		resource["options"] = job.Options
+		if job.InputInfo != nil {
+			resource["input"] = job.InputInfo
+		}
+	}
+
+	return resource
+}
+
+func (s *Server) transformAttempts(ctx context.Context, transformId string) ([]*state.Attempt, *apiError) {
+	attempts, err := s.transforms.Attempts(ctx, transformId)
+	if err != nil {
+		log.Printf("transform: get attempts from state storage: %s\n", err.Error())
+		return nil, errInternal()
+	}
+	return attempts, nil
+}
+
+// splitTransformPath splits "{id}/{subresource}" path.
+func splitTransformPath(path string) (string, string) {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,20 @@
+package internal
+
+import "context"
+
+type stageReporterKey struct{}
+
+// WithStageReporter returns a context which passes stages reported by comixifiers to report.
+func WithStageReporter(ctx context.Context, report func(stage string)) context.Context {
+	return context.WithValue(ctx, stageReporterKey{}, report)
+}
+
+// ReportStage tells which step of the transform is in progress.
+// It does nothing if ctx has no stage reporter.
+func ReportStage(ctx context.Context, stage string) {
+	report, ok := ctx.Value(stageReporterKey{}).(func(stage string))
+	if !ok {
+		return
+	}
+	report(stage)
+}
@@ -0,0 +1,70 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// GroupTTL is how long batches, comparisons and transforms of their items are kept.
+const GroupTTL = 24 * time.Hour
+
+var ErrBatchNotFound = errors.New("batch not found")
+
+// Batch is a group of transforms of many images with the same comixifier.
+type Batch struct {
+	BatchId    string       `json:"batchId"`
+	Comixifier string       `json:"comixifier"`
+	Items      []*BatchItem `json:"items"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	// KeyId is the API key which created the batch.
+	KeyId string `json:"keyId,omitempty"`
+}
+
+// BatchItem is an image of the batch.
+type BatchItem struct {
+	// Name is a path in the archive or a key in the image storage the image came from.
+	Name        string `json:"name"`
+	TransformId string `json:"transformId,omitempty"`
+	// Error tells why the image was rejected before a transform was created.
+	Error string `json:"error,omitempty"`
+}
+
+// CreateBatch saves the batch. Transforms of its items must be created beforehand.
+func (s *Storage) CreateBatch(ctx context.Context, batch *Batch) error {
+	jsonBatch, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("marshal batch to json: %w", err)
+	}
+
+	err = s.client.Set(ctx, batchKey(batch.BatchId), jsonBatch, GroupTTL).Err()
+	if err != nil {
+		return fmt.Errorf("set batch: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) Batch(ctx context.Context, id string) (*Batch, error) {
+	jsonBatch, err := s.client.Get(ctx, batchKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get batch: %w", err)
+	}
+
+	batch := &Batch{}
+	err = json.Unmarshal(jsonBatch, batch)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal batch from json: %w", err)
+	}
+	return batch, nil
+}
+
+func batchKey(id string) string {
+	return id + "-batch"
+}
@@ -0,0 +1,65 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrComparisonNotFound = errors.New("comparison not found")
+
+// Comparison is a group of transforms of the same image by different comixifiers.
+type Comparison struct {
+	ComparisonId string            `json:"comparisonId"`
+	Items        []*ComparisonItem `json:"items"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	// KeyId is the API key which created the comparison.
+	KeyId string `json:"keyId,omitempty"`
+}
+
+// ComparisonItem is a transform of the compared image by one comixifier.
+type ComparisonItem struct {
+	Comixifier  string `json:"comixifier"`
+	TransformId string `json:"transformId,omitempty"`
+	// Error tells why the comixifier was rejected before a transform was created.
+	Error string `json:"error,omitempty"`
+}
+
+// CreateComparison saves the comparison. Transforms of its items must be created beforehand.
+func (s *Storage) CreateComparison(ctx context.Context, comparison *Comparison) error {
+	jsonComparison, err := json.Marshal(comparison)
+	if err != nil {
+		return fmt.Errorf("marshal comparison to json: %w", err)
+	}
+
+	err = s.client.Set(ctx, comparisonKey(comparison.ComparisonId), jsonComparison, GroupTTL).Err()
+	if err != nil {
+		return fmt.Errorf("set comparison: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) Comparison(ctx context.Context, id string) (*Comparison, error) {
+	jsonComparison, err := s.client.Get(ctx, comparisonKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrComparisonNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get comparison: %w", err)
+	}
+
+	comparison := &Comparison{}
+	err = json.Unmarshal(jsonComparison, comparison)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal comparison from json: %w", err)
+	}
+	return comparison, nil
+}
+
+func comparisonKey(id string) string {
+	return id + "-comparison"
+}
@@ -0,0 +1,73 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// leadScript makes the transform the leader of identical transforms in flight or attaches it
+// to the current leader as a follower. It returns the leader id or an empty string for a new leader.
+var leadScript = redis.NewScript(`
+local leader = redis.call('GET', KEYS[1])
+if leader then
+	redis.call('SADD', KEYS[2], ARGV[1])
+	redis.call('PEXPIRE', KEYS[2], ARGV[2])
+	return leader
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return ''
+`)
+
+// releaseScript ends the lead of the transform and returns its followers, so no follower
+// can attach to a leader which has already completed.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return {}
+end
+local followers = redis.call('SMEMBERS', KEYS[2])
+redis.call('DEL', KEYS[1], KEYS[2])
+return followers
+`)
+
+// Lead makes the transform the leader of transforms with the same identity or attaches it to the leader
+// which is in flight already. It returns the leader id or an empty string if the transform leads.
+func (s *Storage) Lead(ctx context.Context, identity string, id string) (string, error) {
+	leaderId, err := leadScript.Run(ctx, s.client, []string{inFlightKey(identity), followersKey(identity)},
+		id, queuedTTL.Milliseconds(),
+	).Text()
+	if err != nil {
+		return "", fmt.Errorf("lead transforms in flight: %w", err)
+	}
+	return leaderId, nil
+}
+
+// Followers returns transforms attached to the leader of the identity.
+func (s *Storage) Followers(ctx context.Context, identity string) ([]string, error) {
+	followers, err := s.client.SMembers(ctx, followersKey(identity)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get followers: %w", err)
+	}
+	return followers, nil
+}
+
+// Release ends the lead of the completed transform and returns its followers.
+// Nothing is returned if the transform doesn't lead.
+func (s *Storage) Release(ctx context.Context, identity string, id string) ([]string, error) {
+	followers, err := releaseScript.Run(ctx, s.client, []string{inFlightKey(identity), followersKey(identity)},
+		id,
+	).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("release transforms in flight: %w", err)
+	}
+	return followers, nil
+}
+
+func inFlightKey(identity string) string {
+	return identity + "-inflight"
+}
+
+func followersKey(identity string) string {
+	return identity + "-followers"
+}
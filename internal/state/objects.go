@@ -0,0 +1,135 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// objectsKey is a sorted set of result objects in the image storage scored by the time they are kept until.
+	objectsKey = "result-objects"
+	// collectedScore marks objects claimed by the garbage collector, which are never kept again.
+	collectedScore = -1
+	// forgetBatch is how many objects are forgotten at once.
+	forgetBatch = 1000
+)
+
+var ErrObjectCollected = errors.New("object is collected as garbage")
+
+// keepScript keeps the object until the time unless it's kept longer or claimed by the garbage collector.
+// KEYS[1] is the objects set, ARGV are the object and the time. It returns 0 for claimed objects.
+var keepScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) < 0 then
+	return 0
+end
+if not score or tonumber(ARGV[2]) > tonumber(score) then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// claimScript marks the object as collected unless it's kept after the time. KEYS[1] is the objects set,
+// ARGV are the object, the time and the collected score. It returns 0 for objects kept after the time.
+var claimScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// KeepObject keeps the result object with its renditions and variants until at least the time.
+// Results shared by cached and merged transforms are kept as long as the longest-lived of them.
+// It returns ErrObjectCollected for objects claimed by the garbage collector.
+func (s *Storage) KeepObject(ctx context.Context, key string, until time.Time) error {
+	kept, err := keepScript.Run(ctx, s.client, []string{objectsKey}, ObjectBase(key), until.Unix()).Int()
+	if err != nil {
+		return fmt.Errorf("keep object: %w", err)
+	}
+	if kept == 0 {
+		return ErrObjectCollected
+	}
+	return nil
+}
+
+// ClaimObject marks the result object with the base as collected unless it's kept after the time,
+// so it can't be kept again while it's removed. It reports whether the object was claimed.
+func (s *Storage) ClaimObject(ctx context.Context, base string, now time.Time) (bool, error) {
+	claimed, err := claimScript.Run(ctx, s.client, []string{objectsKey}, base, now.Unix(), collectedScore).Int()
+	if err != nil {
+		return false, fmt.Errorf("claim object: %w", err)
+	}
+	return claimed == 1, nil
+}
+
+// KeptUntil returns the time the result object with the base is kept until, the zero time if it isn't kept.
+func (s *Storage) KeptUntil(ctx context.Context, base string) (time.Time, error) {
+	score, err := s.client.ZScore(ctx, objectsKey, base).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get object expiry: %w", err)
+	}
+	return time.Unix(int64(score), 0), nil
+}
+
+// ForgetObjects drops result objects with the bases, which were removed from the image storage.
+func (s *Storage) ForgetObjects(ctx context.Context, bases []string) error {
+	for start := 0; start < len(bases); start += forgetBatch {
+		end := start + forgetBatch
+		if end > len(bases) {
+			end = len(bases)
+		}
+
+		members := make([]interface{}, 0, end-start)
+		for _, base := range bases[start:end] {
+			members = append(members, base)
+		}
+		err := s.client.ZRem(ctx, objectsKey, members...).Err()
+		if err != nil {
+			return fmt.Errorf("forget objects: %w", err)
+		}
+	}
+	return nil
+}
+
+// OwnUpload records the API key an upload URL was issued to for d.
+func (s *Storage) OwnUpload(ctx context.Context, key string, keyId string, d time.Duration) error {
+	err := s.client.Set(ctx, uploadOwnerKey(key), keyId, d).Err()
+	if err != nil {
+		return fmt.Errorf("set upload owner: %w", err)
+	}
+	return nil
+}
+
+// UploadOwner returns the API key the upload URL was issued to. It returns ErrNotFound
+// for uploads issued without a key or long ago.
+func (s *Storage) UploadOwner(ctx context.Context, key string) (string, error) {
+	keyId, err := s.client.Get(ctx, uploadOwnerKey(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get upload owner: %w", err)
+	}
+	return keyId, nil
+}
+
+func uploadOwnerKey(key string) string {
+	return key + "-owner"
+}
+
+// ObjectBase is the key of the result object without its extension,
+// which starts keys of its renditions and variants.
+func ObjectBase(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key))
+}
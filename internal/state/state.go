@@ -0,0 +1,503 @@
+package state
+
+import (
+	"comixifier/internal"
+	"comixifier/internal/imaging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ttl = 10 * time.Minute
+	// queuedTTL keeps new transforms until a worker picks them up, which may take long
+	// when a batch of many images is queued at once.
+	queuedTTL = 24 * time.Hour
+)
+
+type Status string
+
+const (
+	StatusWait      Status = "WAIT"
+	StatusFinish    Status = "FINISH"
+	StatusFatal     Status = "FATAL"
+	StatusCancelled Status = "CANCELLED"
+)
+
+// IsFinal reports whether a transform with the status can't change anymore.
+func (s Status) IsFinal() bool {
+	return s == StatusFinish || s == StatusFatal || s == StatusCancelled
+}
+
+// StageQueued is a stage of the transform waiting for a free worker.
+const StageQueued = "queued"
+
+// StageInterrupted is a stage of the transform whose worker was shut down. It waits for a worker
+// of another process to run it again.
+const StageInterrupted = "interrupted"
+
+var ErrNotFound = errors.New("transform not found")
+
+// setStatusScript changes the status unless the transform has already reached a final one,
+// so a cancelled transform is never turned into FINISH or FATAL by its worker.
+var setStatusScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur == 'FINISH' or cur == 'FATAL' or cur == 'CANCELLED' then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// Job is everything a worker needs to run the transform.
+type Job struct {
+	TransformId string           `json:"transformId"`
+	Comixifier  string           `json:"comixifier"`
+	Options     internal.Options `json:"options"`
+	// Input is a key of the source image in the image storage.
+	Input string `json:"input"`
+	// InputBucket is a bucket of the source image if it's not the default one.
+	InputBucket string `json:"inputBucket,omitempty"`
+	// KeepInput is set for images referenced by clients, which are not removed after the transform.
+	KeepInput bool          `json:"keepInput,omitempty"`
+	InputInfo *imaging.Info `json:"inputInfo,omitempty"`
+	// CallbackURL is notified when the transform finishes or fails.
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// GroupId is set for transforms which are items of a batch or a comparison.
+	GroupId string `json:"groupId,omitempty"`
+	// NoFallback keeps the transform to its comixifier when the comixifier fails.
+	NoFallback bool `json:"noFallback,omitempty"`
+	// InFlight is the identity of transforms giving the same result. Identical transforms in flight
+	// are run once by the leader and their followers get its result.
+	InFlight string `json:"inFlight,omitempty"`
+	// KeyId is the API key which created the transform, only that key may use it.
+	KeyId string `json:"keyId,omitempty"`
+	// Retention is how long the completed transform and its result are kept, the default one if it's zero.
+	Retention time.Duration `json:"retention,omitempty"`
+}
+
+// Event is a state of the transform published on every change.
+type Event struct {
+	TransformId string `json:"transformId"`
+	Status      Status `json:"status"`
+	Stage       string `json:"stage,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Interruptions is how many times the transform was interrupted by a shutdown and run again.
+	Interruptions int64 `json:"interruptions,omitempty"`
+}
+
+// Rendition is a size variant of the transform result in the image storage.
+type Rendition struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Attempt is a run of the transform by one comixifier of its fallback chain.
+type Attempt struct {
+	Comixifier string           `json:"comixifier"`
+	Options    internal.Options `json:"options,omitempty"`
+	// Cached is set when the result of the same input was reused instead of running the comixifier.
+	Cached bool `json:"cached,omitempty"`
+	// Called is set when the comixifier was requested, so its account may be charged even if it failed.
+	Called bool `json:"called,omitempty"`
+	// Error tells why the comixifier failed, it's empty for the comixifier which produced the result.
+	Error string `json:"error,omitempty"`
+	// Reason is a kind of the error the next comixifier was tried for.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Storage keeps transforms state in redis.
+type Storage struct {
+	client *redis.Client
+}
+
+func NewStorage(client *redis.Client) *Storage {
+	return &Storage{client: client}
+}
+
+// Create saves the job of a new transform and puts it in WAIT status.
+func (s *Storage) Create(ctx context.Context, job *Job) error {
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal job to json: %w", err)
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, jobKey(job.TransformId), jsonJob, queuedTTL)
+		pipe.Set(ctx, statusKey(job.TransformId), string(StatusWait), queuedTTL)
+		pipe.Set(ctx, stageKey(job.TransformId), StageQueued, queuedTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("set job and status: %w", err)
+	}
+
+	return s.publish(ctx, &Event{
+		TransformId: job.TransformId,
+		Status:      StatusWait,
+		Stage:       StageQueued,
+	})
+}
+
+func (s *Storage) Job(ctx context.Context, id string) (*Job, error) {
+	jsonJob, err := s.client.Get(ctx, jobKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get job: %w", err)
+	}
+
+	job := &Job{}
+	err = json.Unmarshal(jsonJob, job)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal job from json: %w", err)
+	}
+	return job, nil
+}
+
+func (s *Storage) Status(ctx context.Context, id string) (Status, error) {
+	status, err := s.client.Get(ctx, statusKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get status: %w", err)
+	}
+	return Status(status), nil
+}
+
+// Stage returns a step of the transform which is in progress.
+func (s *Storage) Stage(ctx context.Context, id string) (string, error) {
+	stage, err := s.client.Get(ctx, stageKey(id)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("get stage: %w", err)
+	}
+	return stage, nil
+}
+
+// SetStage saves a step of the waiting transform.
+func (s *Storage) SetStage(ctx context.Context, id string, stage string) error {
+	status, err := s.Status(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status.IsFinal() {
+		return nil
+	}
+
+	err = s.client.Set(ctx, stageKey(id), stage, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("set stage: %w", err)
+	}
+
+	return s.publish(ctx, &Event{
+		TransformId: id,
+		Status:      status,
+		Stage:       stage,
+	})
+}
+
+// Interrupt counts the interruption of the transform by a shutdown of its worker and puts it at
+// the interrupted stage, keeping it as long as a new one waits for a worker. It stays in WAIT status.
+func (s *Storage) Interrupt(ctx context.Context, id string) error {
+	status, err := s.Status(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status.IsFinal() {
+		return nil
+	}
+
+	var interruptions *redis.IntCmd
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Expire(ctx, jobKey(id), queuedTTL)
+		pipe.Expire(ctx, statusKey(id), queuedTTL)
+		pipe.Set(ctx, stageKey(id), StageInterrupted, queuedTTL)
+		interruptions = pipe.Incr(ctx, interruptionsKey(id))
+		pipe.Expire(ctx, interruptionsKey(id), queuedTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("set interrupted stage: %w", err)
+	}
+
+	return s.publish(ctx, &Event{
+		TransformId:   id,
+		Status:        status,
+		Stage:         StageInterrupted,
+		Interruptions: interruptions.Val(),
+	})
+}
+
+// Interruptions returns how many times the transform was interrupted.
+func (s *Storage) Interruptions(ctx context.Context, id string) (int64, error) {
+	interruptions, err := s.client.Get(ctx, interruptionsKey(id)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get interruptions: %w", err)
+	}
+	return interruptions, nil
+}
+
+// Snapshot returns the current state of the transform in the same form as published events.
+func (s *Storage) Snapshot(ctx context.Context, id string) (*Event, error) {
+	status, err := s.Status(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	stage, err := s.Stage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	comixifyErr, err := s.Error(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	interruptions, err := s.Interruptions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		TransformId:   id,
+		Status:        status,
+		Stage:         stage,
+		Error:         comixifyErr,
+		Interruptions: interruptions,
+	}, nil
+}
+
+// Subscribe listens to changes of the transform state made by any process.
+func (s *Storage) Subscribe(ctx context.Context, id string) (*Subscription, error) {
+	pubSub := s.client.Subscribe(ctx, eventsChannel(id))
+	_, err := pubSub.Receive(ctx)
+	if err != nil {
+		pubSub.Close()
+		return nil, fmt.Errorf("subscribe to events: %w", err)
+	}
+
+	return newSubscription(pubSub), nil
+}
+
+// Error returns an error message of the failed transform or an empty string.
+func (s *Storage) Error(ctx context.Context, id string) (string, error) {
+	msg, err := s.client.Get(ctx, errorKey(id)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("get error: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *Storage) File(ctx context.Context, id string) (string, error) {
+	file, err := s.client.Get(ctx, fileKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get file: %w", err)
+	}
+	return file, nil
+}
+
+// Renditions returns size variants of the transform result or nil if they weren't generated.
+func (s *Storage) Renditions(ctx context.Context, id string) ([]*Rendition, error) {
+	jsonRenditions, err := s.client.Get(ctx, renditionsKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get renditions: %w", err)
+	}
+
+	renditions := make([]*Rendition, 0)
+	err = json.Unmarshal(jsonRenditions, &renditions)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal renditions from json: %w", err)
+	}
+	return renditions, nil
+}
+
+// SetRenditions saves size variants of the transform result.
+func (s *Storage) SetRenditions(ctx context.Context, id string, renditions []*Rendition) error {
+	jsonRenditions, err := json.Marshal(renditions)
+	if err != nil {
+		return fmt.Errorf("marshal renditions to json: %w", err)
+	}
+
+	err = s.client.Set(ctx, renditionsKey(id), jsonRenditions, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("set renditions: %w", err)
+	}
+	return nil
+}
+
+// Attempts returns comixifiers which ran the transform in order or nil if it hasn't run yet.
+func (s *Storage) Attempts(ctx context.Context, id string) ([]*Attempt, error) {
+	jsonAttempts, err := s.client.Get(ctx, attemptsKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get attempts: %w", err)
+	}
+
+	attempts := make([]*Attempt, 0)
+	err = json.Unmarshal(jsonAttempts, &attempts)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal attempts from json: %w", err)
+	}
+	return attempts, nil
+}
+
+// SetAttempts saves comixifiers which ran the transform.
+func (s *Storage) SetAttempts(ctx context.Context, id string, attempts []*Attempt) error {
+	jsonAttempts, err := json.Marshal(attempts)
+	if err != nil {
+		return fmt.Errorf("marshal attempts to json: %w", err)
+	}
+
+	err = s.client.Set(ctx, attemptsKey(id), jsonAttempts, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("set attempts: %w", err)
+	}
+	return nil
+}
+
+// Finish saves the result file of the transform.
+func (s *Storage) Finish(ctx context.Context, id string, file string) error {
+	err := s.client.Set(ctx, fileKey(id), file, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("set file: %w", err)
+	}
+
+	ok, err := s.setStatus(ctx, id, StatusFinish)
+	if err != nil || !ok {
+		return err
+	}
+
+	return s.publish(ctx, &Event{
+		TransformId: id,
+		Status:      StatusFinish,
+	})
+}
+
+// Fail saves the reason why the transform failed.
+func (s *Storage) Fail(ctx context.Context, id string, comixifyErr error) error {
+	ok, err := s.setStatus(ctx, id, StatusFatal)
+	if err != nil || !ok {
+		return err
+	}
+
+	err = s.client.Set(ctx, errorKey(id), comixifyErr.Error(), ttl).Err()
+	if err != nil {
+		return fmt.Errorf("set error: %w", err)
+	}
+
+	return s.publish(ctx, &Event{
+		TransformId: id,
+		Status:      StatusFatal,
+		Error:       comixifyErr.Error(),
+	})
+}
+
+// Cancel marks the transform as cancelled. It returns false if the transform
+// has already reached a final status.
+func (s *Storage) Cancel(ctx context.Context, id string) (bool, error) {
+	ok, err := s.setStatus(ctx, id, StatusCancelled)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	return true, s.publish(ctx, &Event{
+		TransformId: id,
+		Status:      StatusCancelled,
+	})
+}
+
+// Retain keeps the state of the transform for d from now.
+func (s *Storage) Retain(ctx context.Context, id string, d time.Duration) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range []string{
+			jobKey(id), statusKey(id), stageKey(id), errorKey(id), fileKey(id), renditionsKey(id), attemptsKey(id),
+			interruptionsKey(id),
+		} {
+			pipe.Expire(ctx, key, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("expire transform keys: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) setStatus(ctx context.Context, id string, status Status) (bool, error) {
+	res, err := setStatusScript.Run(ctx, s.client, []string{statusKey(id)},
+		string(status), ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("set status %s: %w", status, err)
+	}
+	return res == 1, nil
+}
+
+func (s *Storage) publish(ctx context.Context, event *Event) error {
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event to json: %w", err)
+	}
+
+	err = s.client.Publish(ctx, eventsChannel(event.TransformId), jsonEvent).Err()
+	if err != nil {
+		return fmt.Errorf("publish event: %w", err)
+	}
+	return nil
+}
+
+func jobKey(id string) string {
+	return id + "-job"
+}
+
+func statusKey(id string) string {
+	return id + "-status"
+}
+
+func stageKey(id string) string {
+	return id + "-stage"
+}
+
+func eventsChannel(id string) string {
+	return id + "-events"
+}
+
+func errorKey(id string) string {
+	return id + "-error"
+}
+
+func fileKey(id string) string {
+	return id + "-file"
+}
+
+func renditionsKey(id string) string {
+	return id + "-renditions"
+}
+
+func attemptsKey(id string) string {
+	return id + "-attempts"
+}
+
+func interruptionsKey(id string) string {
+	return id + "-interruptions"
+}
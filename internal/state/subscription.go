@@ -0,0 +1,54 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Subscription delivers state changes of a single transform.
+type Subscription struct {
+	pubSub *redis.PubSub
+	events chan *Event
+	done   chan struct{}
+}
+
+func newSubscription(pubSub *redis.PubSub) *Subscription {
+	s := &Subscription{
+		pubSub: pubSub,
+		events: make(chan *Event),
+		done:   make(chan struct{}),
+	}
+	go s.decode()
+
+	return s
+}
+
+// Events returns a channel which is closed when the subscription is closed.
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+func (s *Subscription) Close() error {
+	close(s.done)
+	return s.pubSub.Close()
+}
+
+func (s *Subscription) decode() {
+	defer close(s.events)
+
+	for msg := range s.pubSub.Channel() {
+		event := &Event{}
+		err := json.Unmarshal([]byte(msg.Payload), event)
+		if err != nil {
+			log.Printf("state: unmarshal event from json: %s\n", err.Error())
+			continue
+		}
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package usage
+
+import (
+	"comixifier/internal"
+	"comixifier/internal/state"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ledgerKey = "usage-ledger"
+	// keyLedgerPrefix starts streams of records of each key, so reports of a key don't read other keys' records.
+	keyLedgerPrefix = "usage-ledger:"
+	// retention is how long records are kept, longer than a year for yearly reports.
+	retention = 400 * 24 * time.Hour
+	// pageSize is how many records are read from the ledger at once.
+	pageSize = 1000
+)
+
+// Group fields records can be summarized by.
+const (
+	GroupByKey      = "key"
+	GroupByProvider = "provider"
+	GroupByDay      = "day"
+)
+
+var ErrTooManyRecords = errors.New("too many records")
+
+// Record is an attempt of a completed transform in the ledger. Transforms have a record of every
+// comixifier of the fallback chain they tried, the last one tells the outcome of the transform.
+type Record struct {
+	Time        time.Time `json:"time"`
+	TransformId string    `json:"transformId"`
+	KeyId       string    `json:"keyId,omitempty"`
+	Comixifier  string    `json:"comixifier"`
+	// Provider is the comixifier of the attempt, it's the chosen one for transforms which tried none.
+	Provider string           `json:"provider"`
+	Options  internal.Options `json:"options,omitempty"`
+	// Attempt is the number of the attempt in the fallback chain, from 1.
+	Attempt int `json:"attempt,omitempty"`
+	// Fallback is set for attempts the transform fell back from, they aren't counted as transforms.
+	Fallback bool `json:"fallback,omitempty"`
+	// Reason is a kind of the error the attempt failed with.
+	Reason string       `json:"reason,omitempty"`
+	Status state.Status `json:"status"`
+	// Reused is set for results of the cache or of an identical transform, which cost no credits.
+	Reused     bool    `json:"reused,omitempty"`
+	InputSize  int64   `json:"inputSize"`
+	OutputSize int64   `json:"outputSize"`
+	DurationMs int64   `json:"durationMs"`
+	Credits    float64 `json:"credits"`
+}
+
+// Group is a summary of records with the same group fields.
+type Group struct {
+	KeyId      string  `json:"keyId,omitempty"`
+	Provider   string  `json:"provider,omitempty"`
+	Day        string  `json:"day,omitempty"`
+	Transforms int64   `json:"transforms"`
+	Finished   int64   `json:"finished"`
+	Failed     int64   `json:"failed"`
+	Reused     int64   `json:"reused"`
+	Fallbacks  int64   `json:"fallbacks"`
+	InputSize  int64   `json:"inputSize"`
+	OutputSize int64   `json:"outputSize"`
+	DurationMs int64   `json:"durationMs"`
+	Credits    float64 `json:"credits"`
+}
+
+// Ledger keeps records of completed transforms in a redis stream ordered by time,
+// and records of each key in a stream of the key too.
+type Ledger struct {
+	client *redis.Client
+}
+
+func NewLedger(client *redis.Client) *Ledger {
+	return &Ledger{client: client}
+}
+
+// Record appends the records to the ledger at once and drops records older than the retention.
+func (l *Ledger) Record(ctx context.Context, records ...*Record) error {
+	minId := strconv.FormatInt(time.Now().Add(-retention).UnixMilli(), 10)
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, record := range records {
+			jsonRecord, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("marshal record to json: %w", err)
+			}
+
+			streams := []string{ledgerKey}
+			if record.KeyId != "" {
+				streams = append(streams, keyLedgerKey(record.KeyId))
+			}
+			for _, stream := range streams {
+				pipe.XAdd(ctx, &redis.XAddArgs{
+					Stream: stream,
+					MinID:  minId,
+					Approx: true,
+					Values: map[string]interface{}{"record": jsonRecord},
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("add records to ledger: %w", err)
+	}
+	return nil
+}
+
+// Records returns records from from until to, of the key unless it's empty. It fails
+// with ErrTooManyRecords if there are more than max of them.
+func (l *Ledger) Records(ctx context.Context, from time.Time, to time.Time, keyId string, max int) ([]*Record, error) {
+	records := make([]*Record, 0)
+	err := l.scan(ctx, from, to, keyId, func(record *Record) error {
+		if len(records) == max {
+			return ErrTooManyRecords
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Summarize groups records from from until to, of the key unless it's empty, by the group fields.
+// Records are summed up page by page, so periods of any length are summarized in bounded memory.
+func (l *Ledger) Summarize(ctx context.Context, from time.Time, to time.Time, keyId string, groupBy []string) ([]*Group, error) {
+	summary := newSummary(groupBy)
+	err := l.scan(ctx, from, to, keyId, func(record *Record) error {
+		summary.add(record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary.groups(), nil
+}
+
+// scan calls fn with records from from until to, of the key unless it's empty, a page at a time.
+// Records of a key are read from its own stream.
+func (l *Ledger) scan(ctx context.Context, from time.Time, to time.Time, keyId string, fn func(record *Record) error) error {
+	stream := ledgerKey
+	if keyId != "" {
+		stream = keyLedgerKey(keyId)
+	}
+
+	start := strconv.FormatInt(from.UnixMilli(), 10)
+	// The end is exclusive, entries of its millisecond are skipped.
+	end := strconv.FormatInt(to.UnixMilli()-1, 10)
+	for {
+		messages, err := l.client.XRangeN(ctx, stream, start, end, pageSize).Result()
+		if err != nil {
+			return fmt.Errorf("read ledger: %w", err)
+		}
+
+		for _, message := range messages {
+			jsonRecord, _ := message.Values["record"].(string)
+			record := &Record{}
+			err = json.Unmarshal([]byte(jsonRecord), record)
+			if err != nil {
+				return fmt.Errorf("unmarshal record %s from json: %w", message.ID, err)
+			}
+			err = fn(record)
+			if err != nil {
+				return err
+			}
+		}
+		if len(messages) < pageSize {
+			return nil
+		}
+		// "(" excludes the last read entry from the next page.
+		start = "(" + messages[len(messages)-1].ID
+	}
+}
+
+func keyLedgerKey(keyId string) string {
+	return keyLedgerPrefix + keyId
+}
+
+// Summarize groups records by the group fields, groups are sorted by their fields.
+func Summarize(records []*Record, groupBy []string) []*Group {
+	summary := newSummary(groupBy)
+	for _, record := range records {
+		summary.add(record)
+	}
+	return summary.groups()
+}
+
+// summary sums up records by the group fields as they are read.
+type summary struct {
+	groupBy []string
+	byId    map[string]*Group
+}
+
+func newSummary(groupBy []string) *summary {
+	return &summary{
+		groupBy: groupBy,
+		byId:    make(map[string]*Group),
+	}
+}
+
+func (s *summary) add(record *Record) {
+	group := &Group{}
+	for _, field := range s.groupBy {
+		switch field {
+		case GroupByKey:
+			group.KeyId = record.KeyId
+		case GroupByProvider:
+			group.Provider = record.Provider
+		case GroupByDay:
+			group.Day = record.Time.UTC().Format("2006-01-02")
+		}
+	}
+
+	id := strings.Join([]string{group.KeyId, group.Provider, group.Day}, "\n")
+	if existing, ok := s.byId[id]; ok {
+		group = existing
+	} else {
+		s.byId[id] = group
+	}
+
+	group.Credits += record.Credits
+	if record.Fallback {
+		group.Fallbacks++
+		return
+	}
+	group.Transforms++
+	if record.Status == state.StatusFinish {
+		group.Finished++
+	} else {
+		group.Failed++
+	}
+	if record.Reused {
+		group.Reused++
+	}
+	group.InputSize += record.InputSize
+	group.OutputSize += record.OutputSize
+	group.DurationMs += record.DurationMs
+}
+
+// groups returns the groups sorted by their fields.
+func (s *summary) groups() []*Group {
+	summary := make([]*Group, 0, len(s.byId))
+	for _, group := range s.byId {
+		summary = append(summary, group)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		a, b := summary[i], summary[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.KeyId != b.KeyId {
+			return a.KeyId < b.KeyId
+		}
+		return a.Provider < b.Provider
+	})
+	return summary
+}
+
+// IsGroupBy reports whether records can be grouped by the field.
+func IsGroupBy(field string) bool {
+	return field == GroupByKey || field == GroupByProvider || field == GroupByDay
+}
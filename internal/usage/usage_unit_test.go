@@ -0,0 +1,42 @@
+package usage
+
+import (
+	"comixifier/internal/state"
+	"testing"
+	"time"
+)
+
+func TestSummarize_Unit(t *testing.T) {
+	day := time.Date(2024, time.May, 1, 10, 0, 0, 0, time.UTC)
+	records := []*Record{
+		{Time: day, KeyId: "a", Provider: "cutout", Status: state.StatusFinish, InputSize: 10, OutputSize: 20, DurationMs: 100, Credits: 1},
+		{Time: day.Add(time.Hour), KeyId: "a", Provider: "cutout", Status: state.StatusFinish, Reused: true, InputSize: 10, OutputSize: 20},
+		{Time: day.Add(2 * time.Hour), KeyId: "a", Provider: "cutout", Status: state.StatusFatal, InputSize: 10, DurationMs: 50},
+		{Time: day.Add(3 * time.Hour), KeyId: "a", Provider: "cutout", Attempt: 1, Fallback: true, Status: state.StatusFatal, Credits: 1},
+		{Time: day.Add(24 * time.Hour), KeyId: "b", Provider: "VanceAI", Status: state.StatusFinish, Credits: 1},
+	}
+
+	groups := Summarize(records, []string{GroupByKey, GroupByProvider})
+	if len(groups) != 2 {
+		t.Logf("groups got: %d; expected: 2", len(groups))
+		t.FailNow()
+	}
+	a := groups[0]
+	if a.KeyId != "a" || a.Provider != "cutout" || a.Day != "" || a.Transforms != 3 || a.Finished != 2 || a.Failed != 1 ||
+		a.Reused != 1 || a.Fallbacks != 1 || a.InputSize != 30 || a.OutputSize != 40 || a.DurationMs != 150 || a.Credits != 2 {
+		t.Logf("group of key a got: %+v", a)
+		t.FailNow()
+	}
+
+	byDay := Summarize(records, []string{GroupByDay})
+	if len(byDay) != 2 || byDay[0].Day != "2024-05-01" || byDay[0].Transforms != 3 || byDay[1].Day != "2024-05-02" {
+		t.Logf("groups by day got: %+v, %+v", byDay[0], byDay[1])
+		t.FailNow()
+	}
+
+	total := Summarize(records, nil)
+	if len(total) != 1 || total[0].Transforms != 4 || total[0].Fallbacks != 1 || total[0].Credits != 3 {
+		t.Logf("total got: %+v", total)
+		t.FailNow()
+	}
+}
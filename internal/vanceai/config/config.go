@@ -1,35 +1,377 @@
 package config
 
 import (
+	"bytes"
+	"comixifier/internal/cutout"
+	"comixifier/internal/face2comics"
+	"comixifier/internal/imaging"
+	"comixifier/internal/registry"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
 )
 
 var cfg *Config
 
+// Config is everything the server is configured with. Values come from defaults, the YAML file,
+// env and flags, each overriding the previous ones.
+type Config struct {
+	TestMode     interface{}   `short:"t" hidden:"true" yaml:"-"`
+	ConfigFile   string        `short:"c" long:"config" description:"YAML config file, env and flags override it" env:"CONFIG_FILE" yaml:"-"`
+	Server       *Server       `group:"Server" yaml:"server"`
+	StateStorage *StateStorage `group:"State storage" yaml:"stateStorage"`
+	ImageStorage *ImageStorage `group:"Image storage" yaml:"imageStorage"`
+	Worker       *Worker       `group:"Worker" yaml:"worker"`
+	Results      *Results      `group:"Results" yaml:"results"`
+	Auth         *Auth         `group:"Auth" yaml:"auth"`
+	Webhooks     *Webhooks     `group:"Webhooks" yaml:"webhooks"`
+	Providers    *Providers    `group:"Providers" yaml:"providers"`
+}
+
+type Server struct {
+	Addr      string `long:"addr" description:"address the HTTP API listens on" env:"SERVER_ADDR" yaml:"addr"`
+	PublicURL string `long:"public-url" description:"URL clients reach the server at, download links start with it" env:"PUBLIC_URL" yaml:"publicUrl"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are the ones of http.Server.
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" description:"how long reading request headers may take" env:"SERVER_READ_HEADER_TIMEOUT" yaml:"readHeaderTimeout"`
+	ReadTimeout       time.Duration `long:"read-timeout" description:"how long reading a request with its body may take, 0 is unlimited" env:"SERVER_READ_TIMEOUT" yaml:"readTimeout"`
+	WriteTimeout      time.Duration `long:"write-timeout" description:"how long writing a response may take, 0 is unlimited, which event streams need" env:"SERVER_WRITE_TIMEOUT" yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `long:"idle-timeout" description:"how long keep-alive connections wait for the next request" env:"SERVER_IDLE_TIMEOUT" yaml:"idleTimeout"`
+	// ShutdownTimeout is how long requests and running transforms may take to complete on shutdown.
+	// Transforms running after it are returned to the queue.
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" description:"how long shutdown waits for requests and running transforms to complete" env:"SERVER_SHUTDOWN_TIMEOUT" yaml:"shutdownTimeout"`
+}
+
+// StateStorage is the redis keeping transforms state, the queue and the rest of the server data.
+type StateStorage struct {
+	Endpoint     string        `long:"state-storage-endpoint" description:"redis host:port" env:"STATE_STORAGE_ENDPOINT" yaml:"endpoint"`
+	Password     string        `long:"state-storage-password" description:"redis password" env:"STATE_STORAGE_PASSWORD" yaml:"password" default-mask:"-"`
+	DB           int           `long:"state-storage-db" description:"redis database" env:"STATE_STORAGE_DB" yaml:"db"`
+	DialTimeout  time.Duration `long:"state-storage-dial-timeout" description:"how long connecting to redis may take" env:"STATE_STORAGE_DIAL_TIMEOUT" yaml:"dialTimeout"`
+	ReadTimeout  time.Duration `long:"state-storage-read-timeout" description:"how long reading a redis reply may take" env:"STATE_STORAGE_READ_TIMEOUT" yaml:"readTimeout"`
+	WriteTimeout time.Duration `long:"state-storage-write-timeout" description:"how long writing a redis command may take" env:"STATE_STORAGE_WRITE_TIMEOUT" yaml:"writeTimeout"`
+}
+
+// ImageStorage is the MinIO keeping inputs and results.
+type ImageStorage struct {
+	Endpoint  string `long:"image-storage-endpoint" description:"minio host:port" env:"IMAGE_STORAGE_ENDPOINT" yaml:"endpoint"`
+	AccessKey string `long:"image-storage-access-key" description:"minio access key" env:"IMAGE_STORAGE_ACCESS_KEY" yaml:"accessKey" default-mask:"-"`
+	SecretKey string `long:"image-storage-secret-key" description:"minio secret key" env:"IMAGE_STORAGE_SECRET_KEY" yaml:"secretKey" default-mask:"-"`
+	Region    string `long:"image-storage-region" description:"minio region" env:"IMAGE_STORAGE_REGION" yaml:"region"`
+	Bucket    string `long:"image-storage-bucket" description:"bucket of inputs and results" env:"IMAGE_STORAGE_BUCKET" yaml:"bucket"`
+	// InputBuckets are buckets besides the default one clients may reference input images in.
+	InputBuckets []string `long:"input-bucket" description:"bucket clients may reference input images in, repeat for more" env:"INPUT_BUCKETS" env-delim:"," yaml:"inputBuckets"`
+	TLS          bool     `long:"image-storage-tls" description:"connect to minio over TLS" env:"IMAGE_STORAGE_TLS" yaml:"tls"`
+	// CAFile is a PEM bundle of certificate authorities trusted besides the system ones.
+	CAFile        string `long:"image-storage-ca-file" description:"PEM file of CA certificates to trust" env:"IMAGE_STORAGE_CA_FILE" yaml:"caFile"`
+	TLSSkipVerify bool   `long:"image-storage-tls-skip-verify" description:"don't verify the minio certificate" env:"IMAGE_STORAGE_TLS_SKIP_VERIFY" yaml:"tlsSkipVerify"`
+}
+
+type Worker struct {
+	PoolSize int `long:"worker-pool-size" description:"how many transforms run at once" env:"WORKER_POOL_SIZE" yaml:"poolSize"`
+	// Renditions are sizes of result renditions like "thumbnail:256,medium:1024".
+	Renditions string `long:"renditions" description:"sizes of result renditions like thumbnail:256,medium:1024" env:"RENDITIONS" yaml:"renditions"`
+	// FallbackChains are provider fallback chains like "VanceAI>cutout>face2comics".
+	FallbackChains string `long:"fallback-chains" description:"provider fallback chains like VanceAI>cutout>face2comics" env:"FALLBACK_CHAINS" yaml:"fallbackChains"`
+}
+
+type Results struct {
+	CacheTTL   time.Duration `long:"result-cache-ttl" description:"how long results are reused for the same input, 0 disables the cache" env:"RESULT_CACHE_TTL" yaml:"cacheTtl"`
+	Retention  time.Duration `long:"result-retention" description:"how long completed transforms and their results are kept by default" env:"RESULT_RETENTION" yaml:"retention"`
+	GCInterval time.Duration `long:"gc-interval" description:"how often garbage of the image storage is collected, 0 disables garbage collection" env:"GC_INTERVAL" yaml:"gcInterval"`
+}
+
+type Auth struct {
+	Disabled   bool   `long:"auth-disabled" description:"serve clients without API keys" env:"AUTH_DISABLED" yaml:"disabled"`
+	AdminToken string `long:"admin-token" description:"token of admin endpoints, they are disabled without it" env:"ADMIN_TOKEN" yaml:"adminToken" default-mask:"-"`
+}
+
+type Webhooks struct {
+	Secret      string        `long:"webhook-secret" description:"secret webhooks are signed with, callbacks are disabled without it" env:"WEBHOOK_SECRET" yaml:"secret" default-mask:"-"`
+	MaxAttempts int           `long:"webhook-max-attempts" description:"how many times a webhook is sent before giving up" env:"WEBHOOK_MAX_ATTEMPTS" yaml:"maxAttempts"`
+	Timeout     time.Duration `long:"webhook-timeout" description:"how long sending a webhook may take" env:"WEBHOOK_TIMEOUT" yaml:"timeout"`
+}
+
+type Providers struct {
+	VanceAI     *VanceAI            `group:"VanceAI" yaml:"vanceai"`
+	Cutout      *cutout.Config      `group:"cutout" yaml:"cutout"`
+	Face2Comics *face2comics.Config `group:"face2comics" yaml:"face2comics"`
+}
+
+// Default is the config of a local development server.
+func Default() *Config {
+	return &Config{
+		Server: &Server{
+			Addr:              ":9001",
+			PublicURL:         "http://127.0.0.1:9001",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       5 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   25 * time.Second,
+		},
+		StateStorage: &StateStorage{
+			DialTimeout:  5 * time.Second,
+			ReadTimeout:  3 * time.Second,
+			WriteTimeout: 3 * time.Second,
+		},
+		ImageStorage: &ImageStorage{
+			AccessKey: "minioadmin",
+			SecretKey: "minioadmin",
+			Bucket:    "test",
+		},
+		Worker: &Worker{
+			PoolSize: 4,
+		},
+		Results: &Results{
+			CacheTTL:   24 * time.Hour,
+			Retention:  24 * time.Hour,
+			GCInterval: time.Hour,
+		},
+		Auth: &Auth{},
+		Webhooks: &Webhooks{
+			MaxAttempts: 8,
+			Timeout:     10 * time.Second,
+		},
+		Providers: &Providers{
+			VanceAI:     DefaultVanceAI(),
+			Cutout:      cutout.DefaultConfig(),
+			Face2Comics: face2comics.DefaultConfig(),
+		},
+	}
+}
+
+// Load reads the config of the command line args and validates it. The help asked by args is returned as an error,
+// see IsHelp.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	path, err := configFile(args)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		err = cfg.readFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg.Providers.VanceAI.readDeprecatedEnv()
+
+	_, err = flags.NewParser(cfg, flags.HelpFlag|flags.PassDoubleDash).ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ImageStorage.InputBuckets = trimList(cfg.ImageStorage.InputBuckets)
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Setup parses the config from flags and env, as tests calling the VanceAI API do.
+// Only the VanceAI config is validated.
 func Setup() error {
-	cfg = &Config{}
-	parser := flags.NewParser(cfg, flags.Default)
-	_, err := parser.Parse()
+	c := Default()
+	c.Providers.VanceAI.readDeprecatedEnv()
+	_, err := flags.NewParser(c, flags.Default).Parse()
 	if err != nil {
 		return fmt.Errorf("parse config: %w", err)
 	}
+	err = c.Providers.VanceAI.Validate()
+	if err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+	cfg = c
 	return nil
 }
 
-func ApiVanceAI() *apiVanceAI {
-	return cfg.VanceAI
+// ApiVanceAI returns the VanceAI config, the default one until the config is set up or used.
+func ApiVanceAI() *VanceAI {
+	if cfg == nil {
+		return DefaultVanceAI()
+	}
+	return cfg.Providers.VanceAI
 }
 
-type Config struct {
-	TestMode interface{} `short:"t" hidden:"true"`
-	VanceAI  *apiVanceAI
+// IsHelp reports whether the error of Load is the help asked by args, its message is the help then.
+func IsHelp(err error) bool {
+	var flagsErr *flags.Error
+	return errors.As(err, &flagsErr) && flagsErr.Type == flags.ErrHelp
 }
 
-type apiVanceAI struct {
-	ApiToken     string `long:"vanceai-api-token" description:"token for making vanceai api requests" env:"APP_VANCEAI_API_TOKEN" required:"true"`
-	UploadURL    string `long:"vanceai-api-upload-url" description:"url to call Upload endpoint" env:"APP_VANCEAI_UPLOAD_URL" required:"true"`
-	TransformURL string `long:"vanceai-api-transform-url" description:"url to call Transform endpoint" env:"APP_VANCEAI_TRANSFORM_URL" required:"true"`
-	ProgressURL  string `long:"vanceai-api-progress-url" description:"url to call Progress endpoint" env:"APP_VANCEAI_PROGRESS_URL" required:"true"`
-	DownloadURL  string `long:"vanceai-api-download-url" description:"url to call Download endpoint" env:"APP_VANCEAI_DOWNLOAD_URL" required:"true"`
+// configFile finds the config file in args or env before the rest of the config is read.
+func configFile(args []string) (string, error) {
+	opts := struct {
+		ConfigFile string `short:"c" long:"config" env:"CONFIG_FILE"`
+	}{}
+	_, err := flags.NewParser(&opts, flags.IgnoreUnknown).ParseArgs(args)
+	if err != nil {
+		return "", fmt.Errorf("parse config file flag: %w", err)
+	}
+	return opts.ConfigFile, nil
+}
+
+// readFile reads the YAML file over the config. Unknown fields are rejected, so typos don't go unnoticed.
+func (c *Config) readFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the whole config and reports every problem at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server addr is required")
+	check(isHTTPURL(c.Server.PublicURL), "public url must be an absolute http url, got %q", c.Server.PublicURL)
+	check(c.Server.ReadHeaderTimeout >= 0 && c.Server.ReadTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0,
+		"server timeouts must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "shutdown timeout must be positive, got %s", c.Server.ShutdownTimeout)
+
+	check(c.StateStorage.Endpoint != "", "state storage endpoint is required")
+	check(c.StateStorage.DB >= 0, "state storage db must not be negative, got %d", c.StateStorage.DB)
+	check(c.StateStorage.DialTimeout >= 0 && c.StateStorage.ReadTimeout >= 0 && c.StateStorage.WriteTimeout >= 0,
+		"state storage timeouts must not be negative")
+
+	check(c.ImageStorage.Endpoint != "", "image storage endpoint is required")
+	check(!strings.Contains(c.ImageStorage.Endpoint, "://"),
+		"image storage endpoint must be host:port without a scheme, use image storage tls for https, got %q", c.ImageStorage.Endpoint)
+	check(c.ImageStorage.AccessKey != "" && c.ImageStorage.SecretKey != "", "image storage access key and secret key are required")
+	check(c.ImageStorage.Bucket != "", "image storage bucket is required")
+	check(c.ImageStorage.TLS || (c.ImageStorage.CAFile == "" && !c.ImageStorage.TLSSkipVerify),
+		"image storage ca file and tls skip verify need image storage tls")
+	if c.ImageStorage.TLS {
+		_, err := c.ImageStorage.TLSConfig()
+		check(err == nil, "%v", err)
+	}
+
+	check(c.Worker.PoolSize >= 1, "worker pool size must be positive, got %d", c.Worker.PoolSize)
+	_, err := c.Worker.ParseRenditions()
+	check(err == nil, "renditions: %v", err)
+	fallbacks, err := c.Worker.ParseFallbacks()
+	check(err == nil, "fallback chains: %v", err)
+	if c.Providers.VanceAI.Disabled {
+		for provider, chain := range fallbacks {
+			check(provider != Name && !contains(chain, Name), "fallback chains: vanceai is disabled, got it in the chain of %s", provider)
+		}
+	}
+
+	check(c.Results.CacheTTL >= 0, "result cache ttl must not be negative, got %s", c.Results.CacheTTL)
+	check(c.Results.Retention >= time.Minute, "result retention must be at least a minute, got %s", c.Results.Retention)
+	check(c.Results.GCInterval >= 0, "gc interval must not be negative, got %s", c.Results.GCInterval)
+
+	check(c.Webhooks.MaxAttempts >= 1, "webhook max attempts must be positive, got %d", c.Webhooks.MaxAttempts)
+	check(c.Webhooks.Timeout > 0, "webhook timeout must be positive, got %s", c.Webhooks.Timeout)
+
+	for _, providerErr := range []error{
+		c.Providers.VanceAI.Validate(),
+		c.Providers.Cutout.Validate(),
+		c.Providers.Face2Comics.Validate(),
+	} {
+		check(providerErr == nil, "%v", providerErr)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// UseProviders sets configs of providers transforms are run with. Disabled providers are unregistered.
+func (c *Config) UseProviders() {
+	cfg = c
+	if c.Providers.VanceAI.Disabled {
+		registry.Unregister(Name)
+	}
+	cutout.Use(c.Providers.Cutout)
+	face2comics.Use(c.Providers.Face2Comics)
+}
+
+// ParseRenditions returns renditions of results, the default ones unless they are set.
+func (w *Worker) ParseRenditions() ([]imaging.Rendition, error) {
+	if w.Renditions == "" {
+		return imaging.DefaultRenditions, nil
+	}
+	return imaging.ParseRenditions(w.Renditions)
+}
+
+// ParseFallbacks returns fallback chains of providers, which must be registered.
+func (w *Worker) ParseFallbacks() (registry.Fallbacks, error) {
+	return registry.ParseFallbacks(w.FallbackChains)
+}
+
+// TLSConfig returns the TLS config of connections to the image storage.
+func (s *ImageStorage) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Skipping verification is asked for explicitly, for self-signed certificates of development setups.
+		InsecureSkipVerify: s.TLSSkipVerify,
+	}
+	if s.CAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(s.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read image storage ca file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("image storage ca file %s has no PEM certificates", s.CAFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
+
+func isHTTPURL(rawURL string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	return err == nil && (parsedURL.Scheme == "http" || parsedURL.Scheme == "https") && parsedURL.Host != ""
+}
+
+func contains(list []string, element string) bool {
+	for _, e := range list {
+		if e == element {
+			return true
+		}
+	}
+	return false
+}
+
+// trimList drops spaces around elements and empty elements.
+func trimList(list []string) []string {
+	trimmed := make([]string, 0, len(list))
+	for _, element := range list {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			trimmed = append(trimmed, element)
+		}
+	}
+	return trimmed
 }
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"comixifier/internal"
+	"comixifier/internal/registry"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Logf("write config file: %s", err.Error())
+		t.FailNow()
+	}
+	return path
+}
+
+func TestLoad_Precedence_Unit(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  addr: ":8000"
+  publicUrl: "https://comixifier.example.com"
+stateStorage:
+  endpoint: "redis:6379"
+imageStorage:
+  endpoint: "minio:9000"
+  inputBuckets: ["photos"]
+worker:
+  poolSize: 2
+results:
+  retention: 48h
+providers:
+  vanceai:
+    apiToken: "token"
+`)
+	t.Setenv("IMAGE_STORAGE_ENDPOINT", "minio.env:9000")
+	t.Setenv("WORKER_POOL_SIZE", "6")
+
+	cfg, err := Load([]string{"--config", path, "--worker-pool-size", "8"})
+	if err != nil {
+		t.Logf("load config: %s", err.Error())
+		t.FailNow()
+	}
+
+	for _, test := range []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{name: "file over default", got: cfg.Server.Addr, expected: ":8000"},
+		{name: "file", got: cfg.StateStorage.Endpoint, expected: "redis:6379"},
+		{name: "env over file", got: cfg.ImageStorage.Endpoint, expected: "minio.env:9000"},
+		{name: "flag over env", got: cfg.Worker.PoolSize, expected: 8},
+		{name: "file duration", got: cfg.Results.Retention, expected: 48 * time.Hour},
+		{name: "default", got: cfg.Results.CacheTTL, expected: 24 * time.Hour},
+		{name: "default bucket", got: cfg.ImageStorage.Bucket, expected: "test"},
+		{name: "file list", got: strings.Join(cfg.ImageStorage.InputBuckets, ","), expected: "photos"},
+		{name: "provider default", got: cfg.Providers.Cutout.Timeout, expected: 2 * time.Minute},
+	} {
+		if test.got != test.expected {
+			t.Logf("%s got: %v; expected: %v", test.name, test.got, test.expected)
+			t.FailNow()
+		}
+	}
+}
+
+func TestLoad_InputBucketsEnv_Unit(t *testing.T) {
+	t.Setenv("STATE_STORAGE_ENDPOINT", "redis:6379")
+	t.Setenv("IMAGE_STORAGE_ENDPOINT", "minio:9000")
+	t.Setenv("INPUT_BUCKETS", " photos, ,avatars ")
+	t.Setenv("VANCEAI_DISABLED", "true")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Logf("load config: %s", err.Error())
+		t.FailNow()
+	}
+	if got := strings.Join(cfg.ImageStorage.InputBuckets, ","); got != "photos,avatars" {
+		t.Logf("input buckets got: %q; expected: %q", got, "photos,avatars")
+		t.FailNow()
+	}
+}
+
+func TestLoad_Invalid_Unit(t *testing.T) {
+	t.Setenv("STATE_STORAGE_ENDPOINT", "")
+	t.Setenv("IMAGE_STORAGE_ENDPOINT", "")
+	t.Setenv("VANCEAI_API_TOKEN", "")
+
+	_, err := Load([]string{
+		"--worker-pool-size", "0", "--result-retention", "10s", "--cutout-api-url", "cutout", "--shutdown-timeout", "0s",
+	})
+	if err == nil {
+		t.Logf("invalid config loaded")
+		t.FailNow()
+	}
+	for _, problem := range []string{
+		"state storage endpoint is required",
+		"image storage endpoint is required",
+		"worker pool size must be positive",
+		"result retention must be at least a minute",
+		"shutdown timeout must be positive",
+		"vanceai api token is required",
+		"cutout",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Logf("error got: %q; expected to report: %q", err.Error(), problem)
+			t.FailNow()
+		}
+	}
+}
+
+func TestLoad_UnknownField_Unit(t *testing.T) {
+	path := writeConfigFile(t, "server:\n  adress: \":8000\"\n")
+
+	_, err := Load([]string{"-c", path})
+	if err == nil || !strings.Contains(err.Error(), "adress") {
+		t.Logf("error got: %v; expected an unknown field error", err)
+		t.FailNow()
+	}
+}
+
+func TestLoad_Help_Unit(t *testing.T) {
+	_, err := Load([]string{"--help"})
+	if !IsHelp(err) {
+		t.Logf("error got: %v; expected help", err)
+		t.FailNow()
+	}
+	if !strings.Contains(err.Error(), "--image-storage-endpoint") || strings.Contains(err.Error(), "minioadmin") {
+		t.Logf("help got: %q; expected options without secrets", err.Error())
+		t.FailNow()
+	}
+}
+
+func TestLoad_VanceAIDeprecatedEnv_Unit(t *testing.T) {
+	t.Setenv("STATE_STORAGE_ENDPOINT", "redis:6379")
+	t.Setenv("IMAGE_STORAGE_ENDPOINT", "minio:9000")
+	t.Setenv("APP_VANCEAI_API_TOKEN", "old")
+	t.Setenv("APP_VANCEAI_UPLOAD_URL", "https://old.example.com/upload")
+	t.Setenv("VANCEAI_UPLOAD_URL", "https://new.example.com/upload")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Logf("load config: %s", err.Error())
+		t.FailNow()
+	}
+	vanceAI := cfg.Providers.VanceAI
+	if vanceAI.ApiToken != "old" || vanceAI.UploadURL != "https://new.example.com/upload" {
+		t.Logf("vanceai got: token %q, upload url %q; expected the old token and the new upload url", vanceAI.ApiToken, vanceAI.UploadURL)
+		t.FailNow()
+	}
+
+	cfg, err = Load([]string{"--vanceai-api-token", "flag"})
+	if err != nil || cfg.Providers.VanceAI.ApiToken != "flag" {
+		t.Logf("vanceai token got: %v, %v; expected the flag over the deprecated env", cfg, err)
+		t.FailNow()
+	}
+}
+
+func TestLoad_VanceAIDisabled_Unit(t *testing.T) {
+	t.Setenv("STATE_STORAGE_ENDPOINT", "redis:6379")
+	t.Setenv("IMAGE_STORAGE_ENDPOINT", "minio:9000")
+	t.Setenv("VANCEAI_API_TOKEN", "")
+	t.Setenv("VANCEAI_DISABLED", "true")
+
+	_, err := Load(nil)
+	if err != nil {
+		t.Logf("load config with vanceai disabled: %s", err.Error())
+		t.FailNow()
+	}
+
+	// The provider package isn't imported by the config, so the stub stands for it.
+	registry.Register(&registry.Provider{Name: Name, New: func() internal.Comixifier { return nil }})
+	_, err = Load([]string{"--fallback-chains", "cutout>VanceAI"})
+	if err == nil || !strings.Contains(err.Error(), "vanceai is disabled") {
+		t.Logf("error got: %v; expected disabled vanceai in a fallback chain to be reported", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Name is the name VanceAI is registered with.
+const Name = "VanceAI"
+
+type VanceAI struct {
+	Disabled     bool          `long:"vanceai-disabled" description:"don't offer vanceai to clients" env:"VANCEAI_DISABLED" yaml:"disabled"`
+	ApiToken     string        `long:"vanceai-api-token" description:"token for making vanceai api requests" env:"VANCEAI_API_TOKEN" yaml:"apiToken"`
+	UploadURL    string        `long:"vanceai-api-upload-url" description:"url to call Upload endpoint" env:"VANCEAI_UPLOAD_URL" yaml:"uploadUrl"`
+	TransformURL string        `long:"vanceai-api-transform-url" description:"url to call Transform endpoint" env:"VANCEAI_TRANSFORM_URL" yaml:"transformUrl"`
+	ProgressURL  string        `long:"vanceai-api-progress-url" description:"url to call Progress endpoint" env:"VANCEAI_PROGRESS_URL" yaml:"progressUrl"`
+	DownloadURL  string        `long:"vanceai-api-download-url" description:"url to call Download endpoint" env:"VANCEAI_DOWNLOAD_URL" yaml:"downloadUrl"`
+	Timeout      time.Duration `long:"vanceai-timeout" description:"how long a transform by vanceai may take, 0 is unlimited" env:"VANCEAI_TIMEOUT" yaml:"timeout"`
+}
+
+// DefaultVanceAI calls the public VanceAI API.
+func DefaultVanceAI() *VanceAI {
+	return &VanceAI{
+		UploadURL:    "https://api-service.vanceai.com/web_api/v1/upload",
+		TransformURL: "https://api-service.vanceai.com/web_api/v1/transform",
+		ProgressURL:  "https://api-service.vanceai.com/web_api/v1/progress",
+		DownloadURL:  "https://api-service.vanceai.com/web_api/v1/download",
+		Timeout:      5 * time.Minute,
+	}
+}
+
+// deprecatedEnv maps env names VanceAI was configured with before to the current ones.
+var deprecatedEnv = []struct {
+	old     string
+	current string
+	field   func(c *VanceAI) *string
+}{
+	{old: "APP_VANCEAI_API_TOKEN", current: "VANCEAI_API_TOKEN", field: func(c *VanceAI) *string { return &c.ApiToken }},
+	{old: "APP_VANCEAI_UPLOAD_URL", current: "VANCEAI_UPLOAD_URL", field: func(c *VanceAI) *string { return &c.UploadURL }},
+	{old: "APP_VANCEAI_TRANSFORM_URL", current: "VANCEAI_TRANSFORM_URL", field: func(c *VanceAI) *string { return &c.TransformURL }},
+	{old: "APP_VANCEAI_PROGRESS_URL", current: "VANCEAI_PROGRESS_URL", field: func(c *VanceAI) *string { return &c.ProgressURL }},
+	{old: "APP_VANCEAI_DOWNLOAD_URL", current: "VANCEAI_DOWNLOAD_URL", field: func(c *VanceAI) *string { return &c.DownloadURL }},
+}
+
+// readDeprecatedEnv reads the env names of deprecatedEnv which are set without the current ones.
+// It's called before flags are parsed, so they still override env.
+func (c *VanceAI) readDeprecatedEnv() {
+	for _, env := range deprecatedEnv {
+		value, ok := os.LookupEnv(env.old)
+		if !ok {
+			continue
+		}
+		if _, ok := os.LookupEnv(env.current); ok {
+			log.Printf("config: %s is ignored for %s, remove it\n", env.old, env.current)
+			continue
+		}
+		log.Printf("config: %s is deprecated, use %s\n", env.old, env.current)
+		*env.field(c) = value
+	}
+}
+
+// Validate checks the token, endpoint URLs and the timeout, unless VanceAI is disabled.
+func (c *VanceAI) Validate() error {
+	if c.Disabled {
+		return nil
+	}
+
+	if c.ApiToken == "" {
+		return fmt.Errorf("vanceai api token is required unless vanceai is disabled")
+	}
+	for _, endpoint := range []struct {
+		name string
+		url  string
+	}{
+		{name: "upload", url: c.UploadURL},
+		{name: "transform", url: c.TransformURL},
+		{name: "progress", url: c.ProgressURL},
+		{name: "download", url: c.DownloadURL},
+	} {
+		if !isHTTPURL(endpoint.url) {
+			return fmt.Errorf("vanceai %s url must be an absolute http url, got %q", endpoint.name, endpoint.url)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("vanceai timeout must not be negative, got %s", c.Timeout)
+	}
+	return nil
+}
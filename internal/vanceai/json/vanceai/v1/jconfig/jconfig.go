@@ -50,10 +50,17 @@ type ToongineerCartoonizer struct {
 	Config_ *ToongineerCartoonizerConfig `json:"config"`
 }
 
+// DefaultCartoonizerModel is a model used by the cartoonizer unless another one is chosen.
+const DefaultCartoonizerModel = "CartoonizeStable"
+
 func NewToongineerCartoonizer() *ToongineerCartoonizer {
+	return NewToongineerCartoonizerWithModel(DefaultCartoonizerModel)
+}
+
+func NewToongineerCartoonizerWithModel(modelName string) *ToongineerCartoonizer {
 	return &ToongineerCartoonizer{
 		Name_:   "cartoonize",
-		Config_: newToongineerCartoonizerConfig(),
+		Config_: newToongineerCartoonizerConfig(modelName),
 	}
 }
 
@@ -77,10 +84,10 @@ type ToongineerCartoonizerConfig struct {
 	OutParams_    *OutParams           `json:"out_params,omitempty"`
 }
 
-func newToongineerCartoonizerConfig() *ToongineerCartoonizerConfig {
+func newToongineerCartoonizerConfig(modelName string) *ToongineerCartoonizerConfig {
 	return &ToongineerCartoonizerConfig{
 		Module_:       "cartoonize",
-		ModuleParams_: newModuleParamsDefault("CartoonizeStable"),
+		ModuleParams_: newModuleParamsDefault(modelName),
 		OutParams_:    nil,
 	}
 }
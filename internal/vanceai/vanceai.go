@@ -1,17 +1,48 @@
 package vanceai
 
 import (
+	"comixifier/internal"
+	"comixifier/internal/imaging"
+	"comixifier/internal/registry"
+	"comixifier/internal/vanceai/config"
 	"comixifier/internal/vanceai/filesystem/local"
 	"comixifier/internal/vanceai/http/vanceai/v1/builtin"
 	builtin2 "comixifier/internal/vanceai/json/vanceai/v1/builtin"
+	"comixifier/internal/vanceai/json/vanceai/v1/jconfig"
 	zap2 "comixifier/internal/vanceai/logger/zap"
 	v1 "comixifier/internal/vanceai/vanceai/v1"
+	v1errors "comixifier/internal/vanceai/vanceai/v1/errors"
+	"comixifier/internal/vanceai/vanceai/v1/image"
+	"context"
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
 	"io"
 	"os"
 )
 
+func init() {
+	registry.Register(&registry.Provider{
+		Name:          config.Name,
+		Description:   "Cartoonizer by VanceAI",
+		InputFormats:  imaging.InputFormats,
+		MaxInputSize:  10 << 20,
+		MaxResolution: 4096,
+		Credits:       1,
+		Options: []registry.Option{
+			{
+				Name:        "modelName",
+				Type:        registry.OptionTypeString,
+				Description: "model of the VanceAI cartoonizer",
+				Default:     jconfig.DefaultCartoonizerModel,
+			},
+		},
+		New: func() internal.Comixifier {
+			return NewVanceAI()
+		},
+	})
+}
+
 type VanceAI struct {
 }
 
@@ -19,7 +50,7 @@ func NewVanceAI() *VanceAI {
 	return &VanceAI{}
 }
 
-func (v *VanceAI) Do(imgData io.Reader) (io.Reader, error) {
+func (v *VanceAI) Do(ctx context.Context, req *internal.Request) (io.Reader, error) {
 	pkgLogger, err := zap.NewDevelopment()
 	if err != nil {
 		return nil, fmt.Errorf("create logger: %w", err)
@@ -28,39 +59,78 @@ func (v *VanceAI) Do(imgData io.Reader) (io.Reader, error) {
 	sugar := pkgLogger.Sugar()
 	logger := zap2.NewLogger(sugar)
 
-	endpoints := builtin.NewEndpoints(
-		"https://api-service.vanceai.com/web_api/v1/upload",
-		"https://api-service.vanceai.com/web_api/v1/transform",
-		"https://api-service.vanceai.com/web_api/v1/progress",
-	)
-	endpoints.SetDownload("https://api-service.vanceai.com/web_api/v1/download")
-	client := builtin.NewClient(os.Getenv("VANCEAI_API_TOKEN"), endpoints)
+	cfg := config.ApiVanceAI()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	endpoints := builtin.NewEndpoints(cfg.UploadURL, cfg.TransformURL, cfg.ProgressURL)
+	endpoints.SetDownload(cfg.DownloadURL)
+	client := builtin.NewClient(cfg.ApiToken, endpoints)
 	respDecoder := builtin2.NewResponseDecoder()
 	jConfigEncoder := builtin2.NewJConfigEncoder()
 	vanceAI := v1.NewVanceAI(client, respDecoder, jConfigEncoder)
 
-	comixifier := v1.NewComixifier(vanceAI, logger)
+	modelName := req.Options["modelName"]
+	if modelName == "" {
+		modelName = jconfig.DefaultCartoonizerModel
+	}
+	comixifier := v1.NewComixifier(vanceAI, logger, image.NewCartoonizerWithModel(modelName))
 
-	imgFile, err := os.Create("in.png")
+	// VanceAI tells the image type by the file extension.
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "image/png"
+	}
+	imgFile, err := os.CreateTemp("", "in_*"+imaging.Extension(contentType))
 	if err != nil {
-		return nil, fmt.Errorf("create image file in.png: %w", err)
+		return nil, fmt.Errorf("create temp image file: %w", err)
 	}
-	_, err = io.Copy(imgFile, imgData)
+	defer os.Remove(imgFile.Name())
+	_, err = io.Copy(imgFile, req.Image)
 	if err != nil {
 		imgFile.Close()
 		return nil, fmt.Errorf("copy image data to file: %w", err)
 	}
 	imgFile.Close()
 
-	imgFile, err = os.Open("in.png")
+	imgFilePath := imgFile.Name()
+	imgFile, err = os.Open(imgFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("open image file in.png: %w", err)
+		return nil, fmt.Errorf("open image file %s: %w", imgFilePath, err)
 	}
+	defer imgFile.Close()
 
 	imgWrapFile, err := local.WrapFile(imgFile)
 	if err != nil {
 		return nil, fmt.Errorf("wrap local file: %w", err)
 	}
 
-	return comixifier.Turn(imgWrapFile)
+	result, err := comixifier.Turn(ctx, imgWrapFile)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return result, nil
+}
+
+// classifyError tells failures of the account and of the service from rejected images by VanceAI error codes.
+func classifyError(err error) error {
+	var apiErr *v1errors.ApiError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch apiErr.Code() {
+	case v1errors.CodeInsufficientCredits:
+		return internal.NewProviderError(internal.ErrorKindQuota, err)
+	case v1errors.CodeInvalidAPIToken:
+		return internal.NewProviderError(internal.ErrorKindAuth, err)
+	case v1errors.CodeInternalError, v1errors.CodeJobUnexpectedFailed:
+		return internal.NewProviderError(internal.ErrorKindUnavailable, err)
+	case v1errors.CodeIllegalParameter, v1errors.CodeFileNotAvailable, v1errors.CodeFileExceedsLimits:
+		return internal.NewProviderError(internal.ErrorKindInput, err)
+	}
+	return err
 }
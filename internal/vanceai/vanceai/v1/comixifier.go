@@ -3,25 +3,35 @@ package v1
 //go:generate mockgen -destination ./v1_mock.go -package v1 --build_flags=--mod=mod integration-vanceai/internal/vanceai/v1 VanceAI
 
 import (
+	"comixifier/internal"
 	"comixifier/internal/vanceai/filesystem"
 	"comixifier/internal/vanceai/logger"
 	"comixifier/internal/vanceai/vanceai/v1/image"
+	"context"
 	"fmt"
 	"io"
 	"time"
 )
 
 type Comixifier struct {
-	vanceAI VanceAI
-	logger  logger.Logger
+	vanceAI    VanceAI
+	logger     logger.Logger
+	processors []image.Processor
 }
 
-func NewComixifier(vanceAI VanceAI, logger logger.Logger) *Comixifier {
-	return &Comixifier{vanceAI: vanceAI, logger: logger}
+// NewComixifier creates a comixifier which applies processors to images,
+// the default cartoonizer is used if there are none.
+func NewComixifier(vanceAI VanceAI, logger logger.Logger, processors ...image.Processor) *Comixifier {
+	if len(processors) == 0 {
+		processors = []image.Processor{image.NewCartoonizer()}
+	}
+
+	return &Comixifier{vanceAI: vanceAI, logger: logger, processors: processors}
 }
 
-func (c *Comixifier) Turn(img filesystem.File) (io.ReadCloser, error) {
+func (c *Comixifier) Turn(ctx context.Context, img filesystem.File) (io.ReadCloser, error) {
 	c.logger.Info("call Upload", nil)
+	internal.ReportStage(ctx, "uploading")
 	uploadReq := NewUploadRequest("ai", img)
 	uploadResp, err := c.vanceAI.Upload(uploadReq)
 	if err != nil {
@@ -29,9 +39,12 @@ func (c *Comixifier) Turn(img filesystem.File) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("upload request: %w", err)
 	}
 
-	processors := []image.Processor{image.NewCartoonizer()}
-	transformReq := NewTransformRequest(uploadResp.Uid(), processors)
+	transformReq := NewTransformRequest(uploadResp.Uid(), c.processors)
 	c.logger.Info("call Transform", map[string]interface{}{"uid": transformReq.uid})
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("before transform request: %w", err)
+	}
+	internal.ReportStage(ctx, "transforming")
 	transformResp, err := c.vanceAI.Transform(transformReq)
 	if err != nil {
 		c.logger.Error("Transform error", map[string]interface{}{"msg": err.Error()})
@@ -41,13 +54,19 @@ func (c *Comixifier) Turn(img filesystem.File) (io.ReadCloser, error) {
 	progressCount := 0
 	progressReq := NewProgressRequest(transformResp.id)
 	status := transformResp.status
+	internal.ReportStage(ctx, "processing")
 	for status == JobStatusProcess || status == JobStatusWaiting {
 		if progressCount == 15 {
 			return nil, fmt.Errorf("progress request limit expired")
 		}
 
 		timer := time.NewTimer(10 * time.Second)
-		<-timer.C
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("wait for progress request: %w", ctx.Err())
+		case <-timer.C:
+		}
 
 		c.logger.Info("call Progress", map[string]interface{}{"jobId": progressReq.id})
 		progressCount++
@@ -63,6 +82,7 @@ func (c *Comixifier) Turn(img filesystem.File) (io.ReadCloser, error) {
 	c.logger.Info("got job status", map[string]interface{}{"status": status.String()})
 	switch status {
 	case JobStatusFinish:
+		internal.ReportStage(ctx, "downloading")
 		downloadReq := NewDownloadRequest(transformResp.id)
 		c.logger.Info("call Download", map[string]interface{}{"jobId": downloadReq.id})
 		downloadResp, err := c.vanceAI.Download(downloadReq)
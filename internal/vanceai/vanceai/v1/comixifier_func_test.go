@@ -6,6 +6,7 @@ import (
 	"comixifier/internal/vanceai/http/vanceai/v1/builtin"
 	builtin2 "comixifier/internal/vanceai/json/vanceai/v1/builtin"
 	zap2 "comixifier/internal/vanceai/logger/zap"
+	"context"
 	"go.uber.org/zap"
 	"io"
 	"log"
@@ -39,7 +40,7 @@ func TestComixifier_Turn_Func(t *testing.T) {
 	imgLocalFile, _ := local.WrapFile(imgFile)
 
 	comixifier := NewComixifier(vanceAI, logger)
-	imgData, err := comixifier.Turn(imgLocalFile)
+	imgData, err := comixifier.Turn(context.Background(), imgLocalFile)
 	checkError(t, err, "comixifier turns image")
 
 	imgOutFile, err := os.Create("../../../samples/out.txt")
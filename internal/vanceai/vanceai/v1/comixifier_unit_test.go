@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"context"
 	"github.com/golang/mock/gomock"
 	"integration-vanceai/internal/filesystem"
 	"integration-vanceai/internal/vanceai/v1/image"
@@ -64,7 +65,7 @@ func TestComixifier_Turn_Unit(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			args, wantOut := test.prepare()
 
-			out, err := comixifier.Turn(args)
+			out, err := comixifier.Turn(context.Background(), args)
 			if test.wantErr == nil {
 				if err != nil {
 					t.Logf("error got: %s; expected: <nil>", err.Error())
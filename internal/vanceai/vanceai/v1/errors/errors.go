@@ -14,6 +14,10 @@ func NewApiError(code Code, msg string) *ApiError {
 	}
 }
 
+func (e *ApiError) Code() Code {
+	return e.code
+}
+
 func (e *ApiError) Error() string {
 	return fmt.Sprintf("api error, code=%d; message=%s", e.code, e.msg)
 }
@@ -7,12 +7,17 @@ type Processor interface {
 }
 
 type Cartoonizer struct {
+	modelName string
 }
 
 func NewCartoonizer() *Cartoonizer {
-	return &Cartoonizer{}
+	return NewCartoonizerWithModel(jconfig.DefaultCartoonizerModel)
+}
+
+func NewCartoonizerWithModel(modelName string) *Cartoonizer {
+	return &Cartoonizer{modelName: modelName}
 }
 
 func (c *Cartoonizer) Map() jconfig.Feature {
-	return jconfig.NewToongineerCartoonizer()
+	return jconfig.NewToongineerCartoonizerWithModel(c.modelName)
 }
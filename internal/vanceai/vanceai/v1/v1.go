@@ -104,7 +104,12 @@ func (v *vanceAI) Transform(r *TransformRequest) (*TransformResponse, error) {
 		return nil, fmt.Errorf("decode transform response: %w", err)
 	}
 	if methodHttpResp.IsError() {
-		return nil, fmt.Errorf("transform response error: %s", methodHttpResp.Msg())
+		errCode, err := errors.MapCode(methodHttpResp.Code())
+		if err != nil {
+			return nil, fmt.Errorf("transform response error: %s", methodHttpResp.Msg())
+		}
+
+		return nil, errors.NewApiError(errCode, methodHttpResp.Msg())
 	}
 
 	jobId := JobId(methodHttpResp.JobId())
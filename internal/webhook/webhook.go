@@ -0,0 +1,253 @@
+package webhook
+
+import (
+	"bytes"
+	"comixifier/internal/state"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	retryKey      = "webhooks-retry"
+	deadLetterKey = "webhooks-dead"
+
+	SignatureHeader = "X-Comixifier-Signature"
+
+	pollInterval = time.Second
+	batchSize    = 10
+	firstBackoff = 5 * time.Second
+	maxBackoff   = time.Hour
+)
+
+// Payload is sent to the callback url when the transform is completed.
+type Payload struct {
+	TransformId string `json:"transformId"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	DownloadURL string `json:"downloadUrl,omitempty"`
+	SentAt      int64  `json:"sentAt"`
+}
+
+// delivery is a scheduled attempt to send the payload.
+type delivery struct {
+	Id        string   `json:"id"`
+	URL       string   `json:"url"`
+	Payload   *Payload `json:"payload"`
+	Attempt   int      `json:"attempt"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+// Dispatcher delivers webhooks with retries. Scheduled deliveries are kept
+// in a redis sorted set by the time of the next attempt, so any process can send them.
+type Dispatcher struct {
+	client      *redis.Client
+	httpClient  *http.Client
+	secret      []byte
+	maxAttempts int
+	publicURL   string
+}
+
+// NewDispatcher creates a dispatcher which signs payloads with secret and gives up after maxAttempts
+// of timeout each. Download links in payloads start with publicURL of the server.
+func NewDispatcher(client *redis.Client, secret string, maxAttempts int, timeout time.Duration, publicURL string) *Dispatcher {
+	return &Dispatcher{
+		client:      client,
+		httpClient:  &http.Client{Timeout: timeout},
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		publicURL:   publicURL,
+	}
+}
+
+// Notify schedules a webhook about the completed transform.
+func (d *Dispatcher) Notify(ctx context.Context, callbackURL string, event *state.Event) error {
+	payload := &Payload{
+		TransformId: event.TransformId,
+		Status:      string(event.Status),
+		Error:       event.Error,
+	}
+	if event.Status == state.StatusFinish {
+		payload.DownloadURL = d.publicURL + "/v2/transforms/" + url.PathEscape(event.TransformId) + "/result"
+	}
+
+	return d.Enqueue(ctx, callbackURL, payload)
+}
+
+// Enqueue schedules the payload to be sent to url as soon as possible.
+func (d *Dispatcher) Enqueue(ctx context.Context, callbackURL string, payload *Payload) error {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("generate delivery id: %w", err)
+	}
+
+	return d.schedule(ctx, &delivery{
+		Id:      id.String(),
+		URL:     callbackURL,
+		Payload: payload,
+	}, time.Now())
+}
+
+// Run sends due deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatchDue(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	members, err := d.client.ZRangeByScore(ctx, retryKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: batchSize,
+	}).Result()
+	if err != nil {
+		log.Printf("webhook: get due deliveries: %s\n", err.Error())
+		return
+	}
+
+	for _, member := range members {
+		if ctx.Err() != nil {
+			return
+		}
+		// Only the process which removed the delivery sends it.
+		removed, err := d.client.ZRem(ctx, retryKey, member).Result()
+		if err != nil {
+			log.Printf("webhook: take due delivery: %s\n", err.Error())
+			continue
+		}
+		if removed == 0 {
+			continue
+		}
+
+		dlv := &delivery{}
+		err = json.Unmarshal([]byte(member), dlv)
+		if err != nil {
+			log.Printf("webhook: unmarshal delivery from json: %s\n", err.Error())
+			continue
+		}
+
+		// The removed delivery is sent or rescheduled even if ctx is done meanwhile, so it isn't lost on shutdown.
+		d.attempt(context.Background(), dlv)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, dlv *delivery) {
+	dlv.Attempt++
+	err := d.send(ctx, dlv)
+	if err == nil {
+		return
+	}
+
+	dlv.LastError = err.Error()
+	log.Printf("webhook: attempt %d of %s to %s: %s\n", dlv.Attempt, dlv.Payload.TransformId, dlv.URL, err.Error())
+
+	if dlv.Attempt >= d.maxAttempts {
+		err = d.deadLetter(ctx, dlv)
+		if err != nil {
+			log.Printf("webhook: move delivery to dead letters: %s\n", err.Error())
+		}
+		return
+	}
+
+	err = d.schedule(ctx, dlv, time.Now().Add(Backoff(dlv.Attempt)))
+	if err != nil {
+		log.Printf("webhook: reschedule delivery: %s\n", err.Error())
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, dlv *delivery) error {
+	dlv.Payload.SentAt = time.Now().Unix()
+	body, err := json.Marshal(dlv.Payload)
+	if err != nil {
+		return fmt.Errorf("marshal payload to json: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dlv.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(d.secret, body))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send http request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *Dispatcher) schedule(ctx context.Context, dlv *delivery, at time.Time) error {
+	jsonDelivery, err := json.Marshal(dlv)
+	if err != nil {
+		return fmt.Errorf("marshal delivery to json: %w", err)
+	}
+
+	err = d.client.ZAdd(ctx, retryKey, &redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: jsonDelivery,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("add delivery to retry set: %w", err)
+	}
+	return nil
+}
+
+func (d *Dispatcher) deadLetter(ctx context.Context, dlv *delivery) error {
+	jsonDelivery, err := json.Marshal(dlv)
+	if err != nil {
+		return fmt.Errorf("marshal delivery to json: %w", err)
+	}
+
+	err = d.client.LPush(ctx, deadLetterKey, jsonDelivery).Err()
+	if err != nil {
+		return fmt.Errorf("push delivery to dead letters: %w", err)
+	}
+	return nil
+}
+
+// Sign returns a hex encoded HMAC-SHA256 of body. Receivers compare it
+// with the X-Comixifier-Signature header to check that the webhook is genuine.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns a delay before the next attempt after the given number of failed ones.
+func Backoff(attempt int) time.Duration {
+	backoff := firstBackoff
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign_Unit(t *testing.T) {
+	got := Sign([]byte("key"), []byte("The quick brown fox jumps over the lazy dog"))
+	want := "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got != want {
+		t.Logf("signature got: %s; expected: %s", got, want)
+		t.FailNow()
+	}
+}
+
+func TestBackoff_Unit(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 5 * time.Second},
+		{attempt: 2, want: 10 * time.Second},
+		{attempt: 4, want: 40 * time.Second},
+		{attempt: 30, want: time.Hour},
+	}
+
+	for _, test := range tests {
+		got := Backoff(test.attempt)
+		if got != test.want {
+			t.Logf("backoff of attempt %d got: %s; expected: %s", test.attempt, got, test.want)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,838 @@
+package worker
+
+import (
+	"bytes"
+	"comixifier/internal"
+	"comixifier/internal/cache"
+	"comixifier/internal/imaging"
+	"comixifier/internal/limit"
+	"comixifier/internal/queue"
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"comixifier/internal/usage"
+	"comixifier/internal/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	cancelChannel = "transforms-cancel"
+	pollInterval  = time.Second
+)
+
+// Pool runs queued transforms with a fixed number of workers.
+type Pool struct {
+	size       int
+	queue      *queue.Queue
+	transforms *state.Storage
+	pubSub     *redis.Client
+	images     *minio.Client
+	bucket     string
+	webhooks   *webhook.Dispatcher
+	renditions []imaging.Rendition
+	fallbacks  registry.Fallbacks
+	cache      *cache.Cache
+	ledger     *usage.Ledger
+	limiter    *limit.Limiter
+	// retention is how long completed transforms and their results are kept unless they set their own.
+	retention time.Duration
+	running   *running
+	// done is closed when Run returns.
+	done chan struct{}
+}
+
+func NewPool(
+	size int,
+	queue *queue.Queue,
+	transforms *state.Storage,
+	pubSub *redis.Client,
+	images *minio.Client,
+	bucket string,
+	webhooks *webhook.Dispatcher,
+	renditions []imaging.Rendition,
+	fallbacks registry.Fallbacks,
+	cache *cache.Cache,
+	ledger *usage.Ledger,
+	limiter *limit.Limiter,
+	retention time.Duration,
+) *Pool {
+	return &Pool{
+		size:       size,
+		queue:      queue,
+		transforms: transforms,
+		pubSub:     pubSub,
+		images:     images,
+		bucket:     bucket,
+		webhooks:   webhooks,
+		renditions: renditions,
+		fallbacks:  fallbacks,
+		cache:      cache,
+		ledger:     ledger,
+		limiter:    limiter,
+		retention:  retention,
+		running:    newRunning(),
+		done:       make(chan struct{}),
+	}
+}
+
+// Run recovers transforms abandoned by dead processes and processes the queue until ctx is done.
+// It returns once the running transforms are completed, see Drain.
+func (p *Pool) Run(ctx context.Context) {
+	defer close(p.done)
+	p.recover(ctx)
+
+	// Running transforms may be cancelled until they are completed, after ctx is done too.
+	cancels := p.pubSub.Subscribe(context.Background(), cancelChannel)
+	defer cancels.Close()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < p.size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+	recoverTicker := time.NewTicker(queue.LeaseTTL)
+	defer recoverTicker.Stop()
+	for done := false; !done; {
+		select {
+		case <-workersDone:
+			done = true
+		case msg := <-cancels.Channel():
+			p.running.cancel(msg.Payload)
+		case <-recoverTicker.C:
+			if ctx.Err() == nil {
+				p.recover(ctx)
+			}
+		}
+	}
+}
+
+// Drain waits for Run to complete the running transforms after its ctx is done. Transforms still running
+// when ctx of Drain is done are interrupted and returned to the queue, so another process runs them.
+func (p *Pool) Drain(ctx context.Context) {
+	select {
+	case <-p.done:
+		return
+	case <-ctx.Done():
+	}
+
+	n := p.running.interrupt()
+	log.Printf("worker: interrupted %d running transforms\n", n)
+	<-p.done
+}
+
+// Cancel stops the transform on whichever process is running it.
+func (p *Pool) Cancel(ctx context.Context, transformId string) error {
+	err := p.pubSub.Publish(ctx, cancelChannel, transformId).Err()
+	if err != nil {
+		return fmt.Errorf("publish cancel: %w", err)
+	}
+	return nil
+}
+
+func (p *Pool) recover(ctx context.Context) {
+	n, err := p.queue.Recover(ctx)
+	if err != nil {
+		log.Printf("worker: recover queue: %s\n", err.Error())
+		return
+	}
+	if n > 0 {
+		log.Printf("worker: recovered %d abandoned transforms\n", n)
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		transformId, err := p.queue.Pop(ctx)
+		if err != nil {
+			if !errors.Is(err, queue.ErrEmpty) {
+				log.Printf("worker: pop transform: %s\n", err.Error())
+			}
+
+			timer := time.NewTimer(pollInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		p.process(transformId)
+	}
+}
+
+func (p *Pool) process(transformId string) {
+	ctx := p.running.start(transformId)
+	defer p.running.finish(transformId)
+
+	go p.renewLease(ctx, transformId)
+
+	job, err := p.transforms.Job(ctx, transformId)
+	if err != nil && p.running.isInterrupted() {
+		p.requeue(transformId)
+		return
+	}
+	if err != nil {
+		log.Printf("worker: get job %s: %s\n", transformId, err.Error())
+		p.ack(transformId, nil)
+		return
+	}
+
+	status, err := p.transforms.Status(ctx, transformId)
+	if err != nil && p.running.isInterrupted() {
+		p.requeue(transformId)
+		return
+	}
+	if err != nil {
+		log.Printf("worker: get status %s: %s\n", transformId, err.Error())
+		p.ack(transformId, job)
+		return
+	}
+	if status.IsFinal() {
+		p.release(job)
+		p.ack(transformId, job)
+		return
+	}
+
+	started := time.Now()
+	err = p.run(ctx, job)
+	if err != nil && p.running.isInterrupted() {
+		// Followers keep waiting for the leader, which runs again.
+		p.requeue(transformId)
+		return
+	}
+	if err != nil {
+		log.Printf("worker: transform %s: %s\n", transformId, err.Error())
+
+		failCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err = p.transforms.Fail(failCtx, transformId, err)
+		if err != nil {
+			log.Printf("worker: save failure of %s to state storage: %s\n", transformId, err.Error())
+		}
+	}
+
+	p.retain(job)
+	p.notify(job)
+	p.account(job, time.Since(started), false)
+	p.release(job)
+	p.ack(transformId, job)
+}
+
+// requeue returns the transform interrupted on shutdown to the queue. If that fails,
+// the lease of the transform expires and it is recovered by another process.
+func (p *Pool) requeue(transformId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := p.transforms.Interrupt(ctx, transformId)
+	if err != nil {
+		log.Printf("worker: save interruption of %s to state storage: %s\n", transformId, err.Error())
+	}
+	err = p.queue.Requeue(ctx, transformId)
+	if err != nil {
+		log.Printf("worker: requeue %s: %s\n", transformId, err.Error())
+		return
+	}
+	log.Printf("worker: requeued interrupted transform %s\n", transformId)
+}
+
+// retain keeps the state and the result of the completed transform for its retention. Batch and comparison
+// items are kept at least as long as their group, so their results can be used with the rest.
+func (p *Pool) retain(job *state.Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	retention := job.Retention
+	if retention == 0 {
+		retention = p.retention
+	}
+	if job.GroupId != "" && retention < state.GroupTTL {
+		retention = state.GroupTTL
+	}
+
+	err := p.transforms.Retain(ctx, job.TransformId, retention)
+	if err != nil {
+		log.Printf("worker: retain %s: %s\n", job.TransformId, err.Error())
+		return
+	}
+
+	file, err := p.transforms.File(ctx, job.TransformId)
+	if errors.Is(err, state.ErrNotFound) {
+		return
+	}
+	if err == nil {
+		err = p.transforms.KeepObject(ctx, file, time.Now().Add(retention))
+	}
+	if err != nil {
+		log.Printf("worker: retain result of %s: %s\n", job.TransformId, err.Error())
+	}
+}
+
+// notify schedules the webhook of the job if it is finished or failed.
+func (p *Pool) notify(job *state.Job) {
+	if job.CallbackURL == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	event, err := p.transforms.Snapshot(ctx, job.TransformId)
+	if err != nil {
+		log.Printf("worker: get state of %s for webhook: %s\n", job.TransformId, err.Error())
+		return
+	}
+	if event.Status != state.StatusFinish && event.Status != state.StatusFatal {
+		return
+	}
+
+	err = p.webhooks.Notify(ctx, job.CallbackURL, event)
+	if err != nil {
+		log.Printf("worker: schedule webhook of %s: %s\n", job.TransformId, err.Error())
+	}
+}
+
+// account records every attempt of the finished or failed transform in the usage ledger, so each
+// provider the transform requested is charged. Reused results cost no credits.
+func (p *Pool) account(job *state.Job, duration time.Duration, reused bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	event, err := p.transforms.Snapshot(ctx, job.TransformId)
+	if err != nil {
+		log.Printf("worker: get state of %s for usage: %s\n", job.TransformId, err.Error())
+		return
+	}
+	if event.Status != state.StatusFinish && event.Status != state.StatusFatal {
+		return
+	}
+
+	record := &usage.Record{
+		Time:        time.Now().UTC(),
+		TransformId: job.TransformId,
+		KeyId:       job.KeyId,
+		Comixifier:  job.Comixifier,
+		Provider:    job.Comixifier,
+		Options:     job.Options,
+		Status:      event.Status,
+		Reused:      reused,
+		DurationMs:  duration.Milliseconds(),
+	}
+	if job.InputInfo != nil {
+		record.InputSize = job.InputInfo.Size
+	}
+
+	if event.Status == state.StatusFinish {
+		file, err := p.transforms.File(ctx, job.TransformId)
+		if err == nil {
+			var info minio.ObjectInfo
+			info, err = p.images.StatObject(ctx, p.bucket, file, minio.StatObjectOptions{})
+			record.OutputSize = info.Size
+		}
+		if err != nil {
+			log.Printf("worker: get result size of %s for usage: %s\n", job.TransformId, err.Error())
+		}
+	}
+
+	attempts, err := p.transforms.Attempts(ctx, job.TransformId)
+	if err != nil {
+		log.Printf("worker: get attempts of %s for usage: %s\n", job.TransformId, err.Error())
+	}
+	err = p.ledger.Record(ctx, attemptRecords(record, attempts)...)
+	if err != nil {
+		log.Printf("worker: record usage of %s: %s\n", job.TransformId, err.Error())
+	}
+}
+
+// attemptRecords makes a record of every attempt from the record of the transform. Attempts the transform
+// fell back from failed and have no sizes nor duration, the last one carries the outcome of the transform.
+func attemptRecords(transform *usage.Record, attempts []*state.Attempt) []*usage.Record {
+	if len(attempts) == 0 {
+		return []*usage.Record{transform}
+	}
+
+	records := make([]*usage.Record, 0, len(attempts))
+	for i, attempt := range attempts {
+		record := *transform
+		if i < len(attempts)-1 {
+			record = usage.Record{
+				Time:        transform.Time,
+				TransformId: transform.TransformId,
+				KeyId:       transform.KeyId,
+				Comixifier:  transform.Comixifier,
+				Fallback:    true,
+				Status:      state.StatusFatal,
+				Reused:      transform.Reused,
+			}
+		}
+		record.Attempt = i + 1
+		record.Provider = attempt.Comixifier
+		record.Options = attempt.Options
+		record.Reason = attempt.Reason
+		if provider, ok := registry.Get(attempt.Comixifier); ok && attempt.Called && !transform.Reused {
+			record.Credits = provider.Credits
+		}
+		records = append(records, &record)
+	}
+	return records
+}
+
+func (p *Pool) run(ctx context.Context, job *state.Job) error {
+	ctx = internal.WithStageReporter(ctx, func(stage string) {
+		stageCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := p.transforms.SetStage(stageCtx, job.TransformId, stage)
+		if err != nil {
+			log.Printf("worker: save stage of %s to state storage: %s\n", job.TransformId, err.Error())
+		}
+		p.followStage(stageCtx, job, stage)
+	})
+
+	resultImgData, attempts, err := p.comixify(ctx, job)
+	p.saveAttempts(job, attempts)
+	if err != nil {
+		return err
+	}
+
+	internal.ReportStage(ctx, "saving")
+	imgFile, err := os.CreateTemp("", fmt.Sprintf(
+		"img_%s_%d_*.png",
+		job.TransformId,
+		time.Now().Unix(),
+	))
+	if err != nil {
+		return fmt.Errorf("create temp img file: %w", err)
+	}
+	defer os.Remove(imgFile.Name())
+	defer imgFile.Close()
+
+	_, err = io.Copy(imgFile, resultImgData)
+	if err != nil {
+		return fmt.Errorf("copy result image data to temp img file: %w", err)
+	}
+
+	uploadCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	uploadInfo, err := p.images.FPutObject(
+		uploadCtx,
+		p.bucket,
+		filepath.Base(imgFile.Name()),
+		imgFile.Name(),
+		minio.PutObjectOptions{
+			ContentType: "image/png",
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("upload image to image storage: %w", err)
+	}
+
+	internal.ReportStage(ctx, "rendering")
+	renditions := p.render(ctx, job, uploadInfo.Key, imgFile)
+
+	saveCtx, cancelSave := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelSave()
+	err = p.transforms.SetRenditions(saveCtx, job.TransformId, renditions)
+	if err != nil {
+		return fmt.Errorf("save renditions to state storage: %w", err)
+	}
+
+	err = p.transforms.Finish(saveCtx, job.TransformId, uploadInfo.Key)
+	if err != nil {
+		return fmt.Errorf("save result to state storage: %w", err)
+	}
+
+	p.cacheResult(saveCtx, job, attempts[len(attempts)-1], &cache.Result{
+		File:       uploadInfo.Key,
+		Renditions: renditions,
+	})
+	return nil
+}
+
+// cacheResult keeps the result for transforms of the same input by the comixifier which produced it.
+func (p *Pool) cacheResult(ctx context.Context, job *state.Job, attempt *state.Attempt, result *cache.Result) {
+	if job.InputInfo == nil || job.InputInfo.SHA256 == "" {
+		return
+	}
+
+	result.Comixifier = attempt.Comixifier
+	err := p.cache.Put(ctx, cache.Key(job.InputInfo.SHA256, attempt.Comixifier, attempt.Options), result)
+	if err == nil && p.cache.Enabled() {
+		// The cached result outlives the transform unless it's retained longer.
+		err = p.transforms.KeepObject(ctx, result.File, time.Now().Add(p.cache.TTL()))
+	}
+	if err != nil {
+		log.Printf("worker: cache result of %s: %s\n", job.TransformId, err.Error())
+	}
+}
+
+// FinishCached finishes the new transform with the result of the same input cached before, without queueing it.
+func (p *Pool) FinishCached(ctx context.Context, job *state.Job, result *cache.Result) error {
+	return p.finishWith(ctx, job, result.File, result.Renditions, []*state.Attempt{{
+		Comixifier: result.Comixifier,
+		Options:    job.Options,
+		Cached:     true,
+	}})
+}
+
+// finishWith finishes the transform which wasn't run with the result of another one.
+func (p *Pool) finishWith(
+	ctx context.Context,
+	job *state.Job,
+	file string,
+	renditions []*state.Rendition,
+	attempts []*state.Attempt,
+) error {
+	err := p.transforms.SetRenditions(ctx, job.TransformId, renditions)
+	if err != nil {
+		return fmt.Errorf("save renditions to state storage: %w", err)
+	}
+	p.saveAttempts(job, attempts)
+
+	err = p.transforms.Finish(ctx, job.TransformId, file)
+	if err != nil {
+		return fmt.Errorf("save result to state storage: %w", err)
+	}
+
+	p.complete(job)
+	return nil
+}
+
+// complete does what every completed transform needs besides its state, for transforms which weren't queued.
+func (p *Pool) complete(job *state.Job) {
+	p.retain(job)
+	p.notify(job)
+	p.account(job, 0, true)
+	p.removeInput(job)
+}
+
+// followStage shows the stage of the leader on the transforms merged with it.
+func (p *Pool) followStage(ctx context.Context, job *state.Job, stage string) {
+	if job.InFlight == "" {
+		return
+	}
+
+	followers, err := p.transforms.Followers(ctx, job.InFlight)
+	if err != nil {
+		log.Printf("worker: get followers of %s: %s\n", job.TransformId, err.Error())
+		return
+	}
+	for _, followerId := range followers {
+		err = p.transforms.SetStage(ctx, followerId, stage)
+		if err != nil {
+			log.Printf("worker: save stage of %s to state storage: %s\n", followerId, err.Error())
+		}
+	}
+}
+
+// release hands the outcome of the completed leader over to the identical transforms merged with it.
+// Followers of a cancelled leader are queued to run on their own.
+func (p *Pool) release(job *state.Job) {
+	if job.InFlight == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	followers, err := p.transforms.Release(ctx, job.InFlight, job.TransformId)
+	if err != nil {
+		log.Printf("worker: release followers of %s: %s\n", job.TransformId, err.Error())
+		return
+	}
+	if len(followers) == 0 {
+		return
+	}
+
+	event, err := p.transforms.Snapshot(ctx, job.TransformId)
+	if err != nil {
+		log.Printf("worker: get state of %s for followers: %s\n", job.TransformId, err.Error())
+		return
+	}
+	var file string
+	var renditions []*state.Rendition
+	if event.Status == state.StatusFinish {
+		file, err = p.transforms.File(ctx, job.TransformId)
+		if err == nil {
+			renditions, err = p.transforms.Renditions(ctx, job.TransformId)
+		}
+		if err != nil {
+			log.Printf("worker: get result of %s for followers: %s\n", job.TransformId, err.Error())
+			return
+		}
+	}
+	attempts, err := p.transforms.Attempts(ctx, job.TransformId)
+	if err != nil {
+		log.Printf("worker: get attempts of %s for followers: %s\n", job.TransformId, err.Error())
+	}
+
+	for _, followerId := range followers {
+		follower, err := p.transforms.Job(ctx, followerId)
+		if err != nil {
+			log.Printf("worker: get job %s: %s\n", followerId, err.Error())
+			continue
+		}
+
+		switch event.Status {
+		case state.StatusFinish:
+			err = p.finishWith(ctx, follower, file, renditions, attempts)
+		case state.StatusFatal:
+			p.saveAttempts(follower, attempts)
+			err = p.transforms.Fail(ctx, followerId, errors.New(event.Error))
+			p.complete(follower)
+		default:
+			err = p.queue.Push(ctx, followerId)
+		}
+		if err != nil {
+			log.Printf("worker: complete follower %s of %s: %s\n", followerId, job.TransformId, err.Error())
+		}
+	}
+}
+
+// comixify runs the transform by its comixifier and then by the fallbacks of the comixifier until one
+// of them produces the result. Failures of accounts and services are handed over, but an image rejected
+// by one comixifier fails the transform.
+func (p *Pool) comixify(ctx context.Context, job *state.Job) (io.Reader, []*state.Attempt, error) {
+	chain := []string{job.Comixifier}
+	if !job.NoFallback {
+		chain = p.fallbacks.Chain(job.Comixifier)
+	}
+
+	attempts := make([]*state.Attempt, 0, len(chain))
+	for i, name := range chain {
+		provider, ok := registry.Get(name)
+		if !ok {
+			return nil, attempts, fmt.Errorf("unknown comixifier: %q", name)
+		}
+
+		options := job.Options
+		if i > 0 {
+			// Options are specific to the chosen comixifier, fallbacks run with their defaults.
+			var err error
+			options, err = provider.ParseOptions(nil)
+			if err != nil {
+				return nil, attempts, fmt.Errorf("default options of %s: %w", name, err)
+			}
+		}
+
+		resultImgData, called, err := p.comixifyWith(ctx, job, provider, options)
+		if err == nil {
+			attempts = append(attempts, &state.Attempt{Comixifier: name, Options: options, Called: called})
+			return resultImgData, attempts, nil
+		}
+
+		kind := internal.ClassifyError(err)
+		attempts = append(attempts, &state.Attempt{
+			Comixifier: name,
+			Options:    options,
+			Called:     called,
+			Error:      err.Error(),
+			Reason:     string(kind),
+		})
+		if ctx.Err() != nil || !kind.Fallback() || i == len(chain)-1 {
+			return nil, attempts, err
+		}
+		log.Printf("worker: %s failed on %s with %s error, falling back to %s\n", name, job.TransformId, kind, chain[i+1])
+	}
+
+	return nil, attempts, fmt.Errorf("no comixifiers to run")
+}
+
+// comixifyWith runs the transform by the provider. It tells whether the provider was requested.
+func (p *Pool) comixifyWith(
+	ctx context.Context,
+	job *state.Job,
+	provider *registry.Provider,
+	options internal.Options,
+) (io.Reader, bool, error) {
+	internal.ReportStage(ctx, "preparing")
+	imgData, err := p.images.GetObject(ctx, p.inputBucket(job), job.Input, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, false, fmt.Errorf("get input image from image storage: %w", err)
+	}
+	defer imgData.Close()
+
+	internal.ReportStage(ctx, "normalizing")
+	normalized, normalizedInfo, err := imaging.Normalize(imgData, provider.MaxResolution)
+	if err != nil {
+		return nil, false, fmt.Errorf("normalize input image: %w", err)
+	}
+
+	err = p.takeProvider(ctx, provider)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resultImgData, err := provider.New().Do(ctx, internal.NewRequest(normalized, normalizedInfo.ContentType, options))
+	if err != nil {
+		return nil, true, fmt.Errorf("comixify image by %s: %w", provider.Name, err)
+	}
+	return resultImgData, true, nil
+}
+
+// takeProvider counts the call of the provider against its limits. A used up provider fails
+// with a quota error, so the transform falls back to the next comixifier.
+func (p *Pool) takeProvider(ctx context.Context, provider *registry.Provider) error {
+	if p.limiter == nil {
+		return nil
+	}
+
+	decision, err := p.limiter.TakeProvider(ctx, provider.Name, time.Now())
+	if err != nil {
+		return fmt.Errorf("take limits of %s: %w", provider.Name, err)
+	}
+	if decision != nil && !decision.Allowed {
+		return internal.NewProviderError(internal.ErrorKindQuota, fmt.Errorf(
+			"comixifier %s is limited to %d transforms, retry after %s",
+			provider.Name, decision.Limit, decision.Reset.UTC().Format(time.RFC3339),
+		))
+	}
+	return nil
+}
+
+// saveAttempts records which comixifiers ran the transform and why the earlier ones were skipped.
+func (p *Pool) saveAttempts(job *state.Job, attempts []*state.Attempt) {
+	if len(attempts) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := p.transforms.SetAttempts(ctx, job.TransformId, attempts)
+	if err != nil {
+		log.Printf("worker: save attempts of %s to state storage: %s\n", job.TransformId, err.Error())
+	}
+}
+
+// render stores size variants of the result next to it. Renditions which can't be made
+// are skipped, the transform is finished with the rest.
+func (p *Pool) render(ctx context.Context, job *state.Job, resultKey string, resultFile *os.File) []*state.Rendition {
+	_, err := resultFile.Seek(0, io.SeekStart)
+	if err != nil {
+		log.Printf("worker: rewind result of %s: %s\n", job.TransformId, err.Error())
+		return nil
+	}
+	img, _, err := image.Decode(resultFile)
+	if err != nil {
+		log.Printf("worker: decode result of %s: %s\n", job.TransformId, err.Error())
+		return nil
+	}
+
+	base := strings.TrimSuffix(resultKey, filepath.Ext(resultKey))
+	renditions := make([]*state.Rendition, 0, len(p.renditions))
+	for _, r := range p.renditions {
+		if r.Name == imaging.RenditionOriginal {
+			renditions = append(renditions, &state.Rendition{
+				Name:   r.Name,
+				Key:    resultKey,
+				Width:  img.Bounds().Dx(),
+				Height: img.Bounds().Dy(),
+			})
+			continue
+		}
+
+		resized := imaging.Resize(img, r.MaxSize)
+		buf := new(bytes.Buffer)
+		err = png.Encode(buf, resized)
+		if err != nil {
+			log.Printf("worker: encode %s rendition of %s: %s\n", r.Name, job.TransformId, err.Error())
+			continue
+		}
+
+		key := base + "_" + r.Name + ".png"
+		_, err = p.images.PutObject(ctx, p.bucket, key, buf, int64(buf.Len()), minio.PutObjectOptions{
+			ContentType: "image/png",
+		})
+		if err != nil {
+			log.Printf("worker: upload %s rendition of %s: %s\n", r.Name, job.TransformId, err.Error())
+			continue
+		}
+
+		renditions = append(renditions, &state.Rendition{
+			Name:   r.Name,
+			Key:    key,
+			Width:  resized.Bounds().Dx(),
+			Height: resized.Bounds().Dy(),
+		})
+	}
+
+	return renditions
+}
+
+func (p *Pool) renewLease(ctx context.Context, transformId string) {
+	ticker := time.NewTicker(queue.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := p.queue.Renew(ctx, transformId)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("worker: renew lease of %s: %s\n", transformId, err.Error())
+			}
+		}
+	}
+}
+
+// ack removes the completed transform from the queue along with its input image.
+// Images referenced by clients are kept.
+func (p *Pool) ack(transformId string, job *state.Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := p.queue.Ack(ctx, transformId)
+	if err != nil {
+		log.Printf("worker: ack %s: %s\n", transformId, err.Error())
+	}
+
+	if job != nil {
+		p.removeInput(job)
+	}
+}
+
+// removeInput removes the input image of the completed transform unless it was referenced by a client.
+func (p *Pool) removeInput(job *state.Job) {
+	if job.Input == "" || job.KeepInput {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := p.images.RemoveObject(ctx, p.inputBucket(job), job.Input, minio.RemoveObjectOptions{})
+	if err != nil {
+		log.Printf("worker: remove input image of %s: %s\n", job.TransformId, err.Error())
+	}
+}
+
+func (p *Pool) inputBucket(job *state.Job) string {
+	if job.InputBucket != "" {
+		return job.InputBucket
+	}
+	return p.bucket
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"comixifier/internal"
+	"comixifier/internal/registry"
+	"comixifier/internal/state"
+	"comixifier/internal/usage"
+	"testing"
+)
+
+func TestAttemptRecords_Unit(t *testing.T) {
+	for _, name := range []string{"records-a", "records-b"} {
+		registry.Register(&registry.Provider{
+			Name:    name,
+			Credits: 2,
+			New:     func() internal.Comixifier { return nil },
+		})
+	}
+
+	transform := &usage.Record{
+		TransformId: "t",
+		Comixifier:  "records-a",
+		Provider:    "records-a",
+		Status:      state.StatusFatal,
+		InputSize:   10,
+		DurationMs:  100,
+	}
+	records := attemptRecords(transform, []*state.Attempt{
+		{Comixifier: "records-a", Called: true, Reason: "quota"},
+		{Comixifier: "records-b", Called: false, Reason: "quota"},
+		{Comixifier: "records-a", Called: true, Reason: "input"},
+	})
+	if len(records) != 3 {
+		t.Logf("records got: %d; expected: 3", len(records))
+		t.FailNow()
+	}
+
+	first, skipped, last := records[0], records[1], records[2]
+	if !first.Fallback || first.Attempt != 1 || first.Credits != 2 || first.InputSize != 0 || first.DurationMs != 0 {
+		t.Logf("first attempt got: %+v; expected a charged fallback without sizes", first)
+		t.FailNow()
+	}
+	if !skipped.Fallback || skipped.Provider != "records-b" || skipped.Credits != 0 {
+		t.Logf("attempt which didn't call its provider got: %+v; expected an uncharged fallback", skipped)
+		t.FailNow()
+	}
+	if last.Fallback || last.Attempt != 3 || last.Status != state.StatusFatal || last.Reason != "input" ||
+		last.Credits != 2 || last.InputSize != 10 || last.DurationMs != 100 {
+		t.Logf("last attempt got: %+v; expected the outcome of the transform", last)
+		t.FailNow()
+	}
+
+	transform.Reused = true
+	reused := attemptRecords(transform, []*state.Attempt{{Comixifier: "records-a", Called: true}})
+	if len(reused) != 1 || reused[0].Credits != 0 {
+		t.Logf("reused records got: %+v; expected one without credits", reused[0])
+		t.FailNow()
+	}
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// running keeps cancel functions of transforms running by this process.
+type running struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	// interrupted is set on shutdown, transforms cancelled since then are requeued instead of failed.
+	interrupted bool
+}
+
+func newRunning() *running {
+	return &running{
+		cancels: make(map[string]context.CancelFunc),
+	}
+}
+
+func (r *running) start(transformId string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancels[transformId] = cancel
+	if r.interrupted {
+		cancel()
+	}
+
+	return ctx
+}
+
+func (r *running) finish(transformId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancel, ok := r.cancels[transformId]; ok {
+		cancel()
+		delete(r.cancels, transformId)
+	}
+}
+
+func (r *running) cancel(transformId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancel, ok := r.cancels[transformId]; ok {
+		cancel()
+	}
+}
+
+// interrupt cancels every running transform and the ones started later. It returns how many were running.
+func (r *running) interrupt() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.interrupted = true
+	for _, cancel := range r.cancels {
+		cancel()
+	}
+	return len(r.cancels)
+}
+
+func (r *running) isInterrupted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.interrupted
+}
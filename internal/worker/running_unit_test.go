@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"testing"
+)
+
+func TestRunning_Interrupt_Unit(t *testing.T) {
+	r := newRunning()
+	ctx := r.start("a")
+	r.finish("b")
+
+	if r.isInterrupted() || ctx.Err() != nil {
+		t.Logf("transform got interrupted before the interruption")
+		t.FailNow()
+	}
+
+	n := r.interrupt()
+	if n != 1 || !r.isInterrupted() || ctx.Err() == nil {
+		t.Logf("interrupt got: %d running, interrupted %t, context error %v; expected: 1 running, interrupted", n, r.isInterrupted(), ctx.Err())
+		t.FailNow()
+	}
+
+	late := r.start("b")
+	if late.Err() == nil {
+		t.Logf("transform started after the interruption got: running; expected: cancelled")
+		t.FailNow()
+	}
+}